@@ -0,0 +1,397 @@
+package http2struct
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrBodyTooLarge is returned when a request body exceeds a configured size
+// limit.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// BodyDecoder decodes a request body read from r into destination.
+type BodyDecoder func(r io.Reader, destination any) error
+
+var (
+	bodyDecodersMu sync.RWMutex
+
+	// bodyDecoders maps the base media type of a request body to the
+	// BodyDecoder that decodes it into the destination.
+	bodyDecoders = map[string]BodyDecoder{
+		"application/json": decodeJSON,
+		"application/xml":  decodeXML,
+		"text/xml":         decodeXML,
+	}
+)
+
+// bodyAliases maps media types that are used interchangeably with a
+// registered one to that media type, so that a single registration covers
+// them all.
+var bodyAliases = map[string]string{
+	"application/x-yaml": "application/yaml",
+	"text/yaml":          "application/yaml",
+	"text/x-yaml":        "application/yaml",
+}
+
+// RegisterBodyDecoder registers decoder for request bodies whose Content-Type
+// has the given base media type, such as "application/yaml". Registering a
+// media type that already has a decoder replaces it, and a nil decoder removes
+// it. Requests whose media type has no decoder leave body fields untouched.
+//
+// JSON ("application/json") and XML ("application/xml" and "text/xml") are
+// registered by default. Media types with a structured syntax suffix, such as
+// "application/vnd.api+json" or "application/atom+xml", use the decoder
+// registered for "application/" followed by the suffix unless they have their
+// own. Likewise, "text/yaml", "text/x-yaml" and "application/x-yaml" use the
+// decoder registered for "application/yaml" unless they have their own.
+func RegisterBodyDecoder(mediaType string, decoder BodyDecoder) {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	bodyDecodersMu.Lock()
+	defer bodyDecodersMu.Unlock()
+
+	if decoder == nil {
+		delete(bodyDecoders, mediaType)
+
+		return
+	}
+
+	bodyDecoders[mediaType] = decoder
+}
+
+// lookupBodyDecoder returns the BodyDecoder for mediaType, preferring the
+// decoders configured on d over the registered ones. Media types with a
+// structured syntax suffix such as "application/vnd.api+json" fall back to the
+// decoder of "application/" followed by the suffix, and aliases to the decoder
+// of the media type they stand for.
+func (d *Decoder) lookupBodyDecoder(mediaType string) (BodyDecoder, bool) {
+	mediaType = strings.ToLower(mediaType)
+	candidates := []string{mediaType}
+
+	_, subtype, _ := strings.Cut(mediaType, "/")
+
+	if i := strings.LastIndex(subtype, "+"); i >= 0 {
+		candidates = append(candidates, "application/"+subtype[i+1:])
+	}
+
+	if alias, ok := bodyAliases[mediaType]; ok {
+		candidates = append(candidates, alias)
+	}
+
+	bodyDecodersMu.RLock()
+	defer bodyDecodersMu.RUnlock()
+
+	for _, candidate := range candidates {
+		if decoder, ok := d.bodyDecoders[candidate]; ok {
+			return decoder, true
+		}
+
+		if decoder, ok := bodyDecoders[candidate]; ok {
+			return decoder, true
+		}
+	}
+
+	return nil, false
+}
+
+func (d *Decoder) convertBody(request *http.Request, destination any) error {
+	if request.ContentLength == 0 {
+		return nil
+	}
+
+	if d.bodyMethods != nil && !slices.Contains(d.bodyMethods, request.Method) {
+		return nil
+	}
+
+	base, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+
+	decode, ok := d.lookupBodyDecoder(strings.TrimSpace(base))
+	if !ok {
+		return nil
+	}
+
+	// Envelopes are a JSON convention, so other formats, such as XML,
+	// are decoded whole.
+	if d.bodyRoot != "" && isJSONType(mediaType(base)) {
+		return d.convertBodyRoot(request.Body, decode, destination)
+	}
+
+	if err := decode(request.Body, destination); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+
+	return nil
+}
+
+// convertBodyRoot decodes the member of a JSON object body named by the
+// configured body root into destination, ignoring the other members.
+func (d *Decoder) convertBodyRoot(r io.Reader, decode BodyDecoder, destination any) error {
+	var envelope map[string]json.RawMessage
+
+	if err := decode(r, &envelope); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+
+	root, ok := envelope[d.bodyRoot]
+	if !ok {
+		return fmt.Errorf("request body has no %q member", d.bodyRoot)
+	}
+
+	if err := decode(bytes.NewReader(root), destination); err != nil {
+		return fmt.Errorf("failed to decode %q member of request body: %w", d.bodyRoot, err)
+	}
+
+	return nil
+}
+
+func decodeJSON(r io.Reader, destination any) error {
+	return json.NewDecoder(r).Decode(destination)
+}
+
+// decodeStrictJSON decodes JSON like decodeJSON but rejects object keys that
+// do not match any field of the destination.
+func decodeStrictJSON(r io.Reader, destination any) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(destination)
+}
+
+func decodeXML(r io.Reader, destination any) error {
+	return xml.NewDecoder(r).Decode(destination)
+}
+
+// hasBody reports whether request carries a body to read, which requests
+// built with a nil body, and server requests without one, do not.
+func hasBody(request *http.Request) bool {
+	return request.Body != nil && request.Body != http.NoBody
+}
+
+// prepareBody wraps the request body so that every reader of it observes the
+// request context, the configured size limits and, when decompress is true,
+// the decompressed content. A compressed body is left as sent otherwise. The
+// returned reader counts the bytes of a decompressed body as sent, and is nil
+// when the body was not decompressed. The returned function stops closing the
+// body on cancellation, for callers to call once nothing reads it anymore.
+func (d *Decoder) prepareBody(request *http.Request, decompress bool) (*countingReader, func(), error) {
+	stop := func() {}
+
+	if hasBody(request) {
+		body := request.Body
+
+		// Closing the body unblocks a read in progress once the request
+		// is cancelled.
+		unwatch := context.AfterFunc(request.Context(), func() {
+			_ = body.Close()
+		})
+
+		stop = func() {
+			unwatch()
+		}
+
+		request.Body = readCloser{Reader: &contextReader{ctx: request.Context(), r: body}, Closer: body}
+	}
+
+	if err := d.limitBody(request); err != nil {
+		stop()
+
+		return nil, nil, fmt.Errorf("failed to limit body: %w", err)
+	}
+
+	var encoded *countingReader
+
+	if decompress {
+		var err error
+
+		encoded, err = d.decompressBody(request)
+		if err != nil {
+			stop()
+
+			return nil, nil, fmt.Errorf("failed to decompress body: %w", err)
+		}
+	}
+
+	if d.reusableBody {
+		if err := bufferBody(request); err != nil {
+			stop()
+
+			return nil, nil, fmt.Errorf("failed to buffer body: %w", err)
+		}
+	}
+
+	return encoded, stop, nil
+}
+
+// bufferBody reads the whole request body into memory and replaces it with a
+// reader over the buffered bytes. request.GetBody returns a fresh copy, which
+// rewindBody uses to make the body readable again once decoding is done.
+func bufferBody(request *http.Request) error {
+	if !hasBody(request) {
+		return nil
+	}
+
+	buf, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+
+	request.Body, _ = request.GetBody()
+	request.ContentLength = int64(len(buf))
+
+	return nil
+}
+
+// rewindBody replaces the body of a request buffered by bufferBody with a
+// fresh reader, so that handlers and middleware can read it from the start.
+func rewindBody(request *http.Request) {
+	if !hasBody(request) || request.GetBody == nil {
+		return
+	}
+
+	request.Body, _ = request.GetBody()
+}
+
+// limitBody makes reads of the request body fail with ErrBodyTooLarge past
+// the configured maximum body size. Bodies declaring a larger Content-Length
+// are rejected before anything is read, and missing bodies are left missing.
+func (d *Decoder) limitBody(request *http.Request) error {
+	if d.maxBodySize <= 0 || !hasBody(request) {
+		return nil
+	}
+
+	if request.ContentLength > d.maxBodySize {
+		return ErrBodyTooLarge
+	}
+
+	request.Body = readCloser{Reader: &limitedReader{r: request.Body, n: d.maxBodySize}, Closer: request.Body}
+
+	return nil
+}
+
+// decompressBody replaces the request body with a decompressing reader when it
+// is sent with a gzip or deflate Content-Encoding, so that the body decoders,
+// form parsing and binary file fields all observe the original content. The
+// Content-Encoding header is removed and the content length becomes unknown.
+func (d *Decoder) decompressBody(request *http.Request) (*countingReader, error) {
+	if request.ContentLength == 0 {
+		return nil, nil
+	}
+
+	var r io.Reader
+
+	encoded := &countingReader{r: request.Body}
+
+	switch strings.ToLower(strings.TrimSpace(request.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip body: %w", err)
+		}
+
+		r = gz
+	case "deflate":
+		zr, err := zlib.NewReader(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deflate body: %w", err)
+		}
+
+		r = zr
+	default:
+		return nil, nil
+	}
+
+	if d.maxDecompressedSize > 0 {
+		r = &limitedReader{r: r, n: d.maxDecompressedSize}
+	}
+
+	request.Body = readCloser{Reader: r, Closer: request.Body}
+	request.ContentLength = -1
+	request.Header.Del("Content-Encoding")
+
+	return encoded, nil
+}
+
+// readCloser combines a reader with the closer of the body it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contextReader fails reads with the error of ctx once it is done, so that
+// reading the body of a cancelled request stops at the next read. A read cut
+// short by the cancellation, such as by closing the body, also returns the
+// error of ctx rather than its own.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	if ctxErr := c.ctx.Err(); err != nil && ctxErr != nil {
+		return n, ctxErr
+	}
+
+	return n, err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// limitedReader reads at most n bytes from r and fails with ErrBodyTooLarge
+// once r holds more data than that, instead of silently truncating it.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var probe [1]byte
+
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+
+		return 0, err
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	return n, err
+}
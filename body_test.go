@@ -0,0 +1,1031 @@
+package http2struct
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBodyRequest returns a POST request to target carrying body with the
+// given Content-Type.
+func newBodyRequest(target, contentType, body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	request.Header.Set("Content-Type", contentType)
+
+	return request
+}
+
+func TestConvertXMLBody(t *testing.T) {
+	type address struct {
+		City    string `xml:"city"`
+		Country string `xml:"country,attr"`
+	}
+
+	type order struct {
+		ID      int      `xml:"id,attr"`
+		Items   []string `xml:"items>item"`
+		Address address  `xml:"address"`
+		Page    int      `query:"page"`
+	}
+
+	const document = `<order id="7"><items><item>a</item><item>b</item></items><address country="TR"><city>Izmir</city></address></order>`
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        order
+	}{
+		{
+			name:        "application/xml",
+			contentType: "application/xml",
+			want:        order{ID: 7, Items: []string{"a", "b"}, Address: address{City: "Izmir", Country: "TR"}, Page: 2},
+		},
+		{
+			name:        "text/xml with charset",
+			contentType: "text/xml; charset=utf-8",
+			want:        order{ID: 7, Items: []string{"a", "b"}, Address: address{City: "Izmir", Country: "TR"}, Page: 2},
+		},
+		{
+			name:        "unknown content type",
+			contentType: "application/octet-stream",
+			want:        order{Page: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got order
+			if err := Convert(newBodyRequest("/?page=2", tt.contentType, document), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertStructuredSyntaxSuffix(t *testing.T) {
+	type resource struct {
+		Name  string `json:"name" xml:"name"`
+		Count int    `json:"count" xml:"count"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        resource
+	}{
+		{
+			name:        "application/ld+json",
+			contentType: "application/ld+json",
+			body:        `{"name":"ada","count":2}`,
+			want:        resource{Name: "ada", Count: 2},
+		},
+		{
+			name:        "application/hal+json with charset",
+			contentType: "application/hal+json; charset=utf-8",
+			body:        `{"name":"ada","count":3}`,
+			want:        resource{Name: "ada", Count: 3},
+		},
+		{
+			name:        "application/vnd.api+json",
+			contentType: "application/vnd.api+json",
+			body:        `{"name":"ada","count":4}`,
+			want:        resource{Name: "ada", Count: 4},
+		},
+		{
+			name:        "application/atom+xml",
+			contentType: "application/atom+xml",
+			body:        `<resource><name>ada</name><count>5</count></resource>`,
+			want:        resource{Name: "ada", Count: 5},
+		},
+		{
+			name:        "json without suffix",
+			contentType: "application/jsonx",
+			body:        `{"name":"ada","count":6}`,
+			want:        resource{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got resource
+			if err := Convert(newBodyRequest("/", tt.contentType, tt.body), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRawMessage(t *testing.T) {
+	type event struct {
+		Type     string          `json:"type"`
+		Metadata json.RawMessage `json:"metadata"`
+		Version  int             `query:"version"`
+	}
+
+	tests := []struct {
+		name     string
+		metadata string
+	}{
+		{name: "object", metadata: `{"id": 1, "tags": ["a", "b"]}`},
+		{name: "array", metadata: `[1, 2,  3]`},
+		{name: "string", metadata: `"plain"`},
+		{name: "null", metadata: `null`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"type": "order", "metadata": ` + tt.metadata + `}`
+
+			var got event
+			if err := Convert(newJSONRequest("/?version=2", body), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !bytes.Equal(got.Metadata, []byte(tt.metadata)) {
+				t.Errorf("Metadata = %s, want %s", got.Metadata, tt.metadata)
+			}
+
+			if got.Type != "order" || got.Version != 2 {
+				t.Errorf("Convert() = %+v, want type order and version 2", got)
+			}
+		})
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		body    string
+		wantErr bool
+	}{
+		{name: "extra field allowed by default", body: `{"name":"ada","extra":1}`},
+		{name: "extra field rejected", opts: []Option{WithDisallowUnknownFields()}, body: `{"name":"ada","extra":1}`, wantErr: true},
+		{name: "known fields accepted", opts: []Option{WithDisallowUnknownFields()}, body: `{"name":"ada"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got user
+
+			err := NewDecoder(tt.opts...).Decode(newJSONRequest("/", tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got.Name != "ada" {
+				t.Errorf("Name = %q, want %q", got.Name, "ada")
+			}
+		})
+	}
+}
+
+func TestConvertContextCancellation(t *testing.T) {
+	type upload struct {
+		File File `file:"binary"`
+	}
+
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		ctx         func() (context.Context, context.CancelFunc)
+		destination any
+		want        error
+	}{
+		{
+			name:        "cancelled binary upload",
+			ctx:         func() (context.Context, context.CancelFunc) { return cancelled, func() {} },
+			destination: &upload{},
+			want:        context.Canceled,
+		},
+		{
+			name: "slow binary upload",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			destination: &upload{},
+			want:        context.DeadlineExceeded,
+		},
+		{
+			name: "slow json body",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			destination: &payload{},
+			want:        context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			// The body sends a few bytes and then blocks until it is closed.
+			body, writer := io.Pipe()
+			defer writer.Close()
+
+			go func() {
+				_, _ = writer.Write([]byte(`{"name":`))
+			}()
+
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request.ContentLength = -1
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Content-Disposition", `attachment; filename="upload.json"`)
+
+			done := make(chan error, 1)
+
+			go func() {
+				done <- Convert(request, tt.destination)
+			}()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Convert() error = %v, want %v", err, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Convert() did not return after the context was done")
+			}
+		})
+	}
+}
+
+// closeRecorder is a request body that reports when it is closed.
+type closeRecorder struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+
+	return nil
+}
+
+func TestConvertContextWatchStopped(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	type stream struct {
+		Body io.Reader `file:"binary"`
+	}
+
+	tests := []struct {
+		name        string
+		destination any
+		wantClosed  bool
+	}{
+		{name: "decoded body left open", destination: &payload{}},
+		{name: "streamed body closed", destination: &stream{}, wantClosed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			body := &closeRecorder{Reader: strings.NewReader(`{"name":"ada"}`), closed: make(chan struct{})}
+
+			request := httptest.NewRequestWithContext(ctx, http.MethodPost, "/", body)
+			request.Header.Set("Content-Type", "application/json")
+
+			if err := Convert(request, tt.destination); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			// Cancelling the request once Convert has returned only closes
+			// a body that a field still streams.
+			cancel()
+
+			select {
+			case <-body.closed:
+				if !tt.wantClosed {
+					t.Error("body closed after Convert() returned, want open")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantClosed {
+					t.Error("body open after the request was cancelled, want closed")
+				}
+			}
+		})
+	}
+}
+
+func TestConvertPlainTextBody(t *testing.T) {
+	type notify struct {
+		Message string `body:""`
+		Raw     []byte `body:""`
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		body    string
+		want    string
+		wantErr error
+	}{
+		{name: "text", body: "hello world", want: "hello world"},
+		{name: "multiline", body: "line one\nline two\n", want: "line one\nline two\n"},
+		{name: "empty", body: "", want: ""},
+		{name: "within limit", opts: []Option{WithMaxBodySize(11)}, body: "hello world", want: "hello world"},
+		{name: "over limit", opts: []Option{WithMaxBodySize(5)}, body: "hello world", wantErr: ErrBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got notify
+
+			err := NewDecoder(tt.opts...).Decode(newBodyRequest("/", "text/plain; charset=utf-8", tt.body), &got)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if got.Message != tt.want || string(got.Raw) != tt.want {
+				t.Errorf("Decode() = %q and %q, want %q", got.Message, got.Raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithReusableBody(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	type upload struct {
+		File File `file:"binary"`
+	}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		request     func() *http.Request
+		destination any
+		want        string
+		wantErr     error
+	}{
+		{
+			name:        "json body",
+			opts:        []Option{WithReusableBody()},
+			request:     func() *http.Request { return newJSONRequest("/", `{"name":"ada"}`) },
+			destination: &payload{},
+			want:        `{"name":"ada"}`,
+		},
+		{
+			name: "binary upload",
+			opts: []Option{WithReusableBody()},
+			request: func() *http.Request {
+				request := newBodyRequest("/", "application/octet-stream", "binary content")
+				request.Header.Set("Content-Disposition", `attachment; filename="a.bin"`)
+
+				return request
+			},
+			destination: &upload{},
+			want:        "binary content",
+		},
+		{
+			name:        "consumed without the option",
+			request:     func() *http.Request { return newJSONRequest("/", `{"name":"ada"}`) },
+			destination: &payload{},
+			want:        "",
+		},
+		{
+			name:        "over the size limit",
+			opts:        []Option{WithReusableBody(), WithMaxBodySize(4)},
+			request:     func() *http.Request { return newJSONRequest("/", `{"name":"ada"}`) },
+			destination: &payload{},
+			wantErr:     ErrBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request()
+
+			err := NewDecoder(tt.opts...).Decode(request, tt.destination)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			for range 2 {
+				body, err := io.ReadAll(request.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				if string(body) != tt.want {
+					t.Fatalf("body = %q, want %q", body, tt.want)
+				}
+
+				if tt.want == "" {
+					return
+				}
+
+				if request.Body, err = request.GetBody(); err != nil {
+					t.Fatalf("GetBody() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name:    "nested object",
+			request: func() *http.Request { return newJSONRequest("/", `{"user":{"name":"ada","tags":["a"]},"count":2}`) },
+			want:    map[string]any{"user": map[string]any{"name": "ada", "tags": []any{"a"}}, "count": float64(2)},
+		},
+		{
+			name:    "no body",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/", nil) },
+			want:    map[string]any{},
+		},
+		{
+			name:    "unsupported content type",
+			request: func() *http.Request { return newBodyRequest("/", "application/x-www-form-urlencoded", "a=b") },
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			request: func() *http.Request { return newJSONRequest("/", `{"user":`) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode[map[string]any](tt.request())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSlice(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+		Qty  int    `json:"qty"`
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    []item
+		wantErr bool
+	}{
+		{
+			name:    "array of objects",
+			request: func() *http.Request { return newJSONRequest("/", `[{"name":"a","qty":1},{"name":"b","qty":2}]`) },
+			want:    []item{{Name: "a", Qty: 1}, {Name: "b", Qty: 2}},
+		},
+		{
+			name:    "no body",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/", nil) },
+			want:    nil,
+		},
+		{
+			name:    "unsupported content type",
+			request: func() *http.Request { return newBodyRequest("/", "application/x-www-form-urlencoded", "name=a") },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode[[]item](tt.request())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// decodeLines is a BodyDecoder for a toy format of "key: value" lines, decoded
+// into destination through JSON.
+func decodeLines(r io.Reader, destination any) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+
+	for line := range strings.Lines(string(content)) {
+		key, value, _ := strings.Cut(line, ":")
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(encoded, destination)
+}
+
+func TestRegisterBodyDecoder(t *testing.T) {
+	RegisterBodyDecoder("application/yaml", decodeLines)
+	t.Cleanup(func() { RegisterBodyDecoder("application/yaml", nil) })
+
+	type config struct {
+		Name string
+		Env  string `yaml:"env"`
+		Page int    `query:"page"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        config
+	}{
+		{name: "registered", contentType: "application/yaml", want: config{Name: "ada", Env: "prod", Page: 2}},
+		{name: "alias", contentType: "text/yaml", want: config{Name: "ada", Env: "prod", Page: 2}},
+		{name: "suffix", contentType: "application/vnd.app+yaml", want: config{Name: "ada", Env: "prod", Page: 2}},
+		{name: "unregistered", contentType: "application/toml", want: config{Page: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got config
+			if err := Convert(newBodyRequest("/?page=2", tt.contentType, "Name: ada\nEnv: prod\n"), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithBodyDecoderSkipsExcludedFields(t *testing.T) {
+	type excluded struct {
+		Secret string `json:"-"`
+		Page   int    `query:"page"`
+	}
+
+	type untagged struct {
+		Name string
+		Page int `query:"page"`
+	}
+
+	tests := []struct {
+		name        string
+		destination any
+		wantCalls   int
+	}{
+		{name: "excluded field", destination: &excluded{}, wantCalls: 0},
+		{name: "untagged field", destination: &untagged{}, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+
+			decoder := NewDecoder(WithBodyDecoder("application/json", func(r io.Reader, destination any) error {
+				calls++
+
+				return json.NewDecoder(r).Decode(destination)
+			}))
+
+			if err := decoder.Decode(newJSONRequest("/?page=2", `{"Name":"ada","Secret":"x"}`), tt.destination); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("body decoder calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithBodyRoot(t *testing.T) {
+	type user struct {
+		Name string `json:"name" xml:"name"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        user
+		wantErr     bool
+	}{
+		{name: "enveloped json", contentType: "application/json", body: `{"data":{"name":"ada"},"meta":{}}`, want: user{Name: "ada"}},
+		{name: "enveloped vendor json", contentType: "application/vnd.api+json", body: `{"data":{"name":"ada"}}`, want: user{Name: "ada"}},
+		{name: "missing root", contentType: "application/json", body: `{"name":"ada"}`, wantErr: true},
+		{name: "xml decoded whole", contentType: "application/xml", body: `<user><name>ada</name></user>`, want: user{Name: "ada"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got user
+
+			err := NewDecoder(WithBodyRoot("data")).Decode(newBodyRequest("/", tt.contentType, tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertTrailers(t *testing.T) {
+	type binaryUpload struct {
+		Upload   *File  `file:"binary"`
+		Checksum string `trailer:"X-Checksum"`
+	}
+
+	type jsonUpload struct {
+		Name     string `json:"name"`
+		Checksum string `trailer:"X-Checksum" required:"true"`
+	}
+
+	type trailerOnly struct {
+		Checksum string `trailer:"X-Checksum"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		trailer     string
+		destination func() any
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "after binary upload",
+			contentType: "application/octet-stream",
+			body:        "file content",
+			trailer:     "abc123",
+			destination: func() any { return &binaryUpload{} },
+			want:        "abc123",
+		},
+		{
+			name:        "after json body",
+			contentType: "application/json",
+			body:        `{"name":"ada"}`,
+			trailer:     "def456",
+			destination: func() any { return &jsonUpload{} },
+			want:        "def456",
+		},
+		{
+			name:        "body left unread",
+			contentType: "text/plain",
+			body:        "ignored body",
+			trailer:     "ghi789",
+			destination: func() any { return &trailerOnly{} },
+			want:        "ghi789",
+		},
+		{
+			name:        "required trailer missing",
+			contentType: "application/json",
+			body:        `{"name":"ada"}`,
+			destination: func() any { return &jsonUpload{} },
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got = tt.destination()
+				err error
+			)
+
+			server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				err = Convert(r, got)
+			}))
+			defer server.Close()
+
+			// An unknown length makes the client send a chunked body, which
+			// trailers follow.
+			request, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(tt.body))
+			request.ContentLength = -1
+			request.Header.Set("Content-Type", tt.contentType)
+			request.Header.Set("Content-Disposition", `attachment; filename="upload.bin"`)
+
+			if tt.trailer != "" {
+				request.Trailer = http.Header{"X-Checksum": {tt.trailer}}
+			}
+
+			response, requestErr := server.Client().Do(request)
+			if requestErr != nil {
+				t.Fatal(requestErr)
+			}
+
+			response.Body.Close()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if checksum := reflect.ValueOf(got).Elem().FieldByName("Checksum").String(); checksum != tt.want {
+				t.Errorf("Checksum = %q, want %q", checksum, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRawBodyWithoutBody(t *testing.T) {
+	type optional struct {
+		Raw string `body:""`
+	}
+
+	type required struct {
+		Raw []byte `body:"" required:"true"`
+	}
+
+	tests := []struct {
+		name        string
+		body        io.Reader
+		destination any
+		want        any
+		wantErr     bool
+	}{
+		{name: "nil body", destination: &optional{}, want: &optional{}},
+		{name: "no body", body: http.NoBody, destination: &optional{}, want: &optional{}},
+		{name: "nil body required", destination: &required{}, wantErr: true},
+		{name: "body", body: strings.NewReader("raw"), destination: &optional{}, want: &optional{Raw: "raw"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodPost, "/", tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Convert(request, tt.destination)
+			if tt.wantErr {
+				var requiredErr *RequiredError
+				if !errors.As(err, &requiredErr) {
+					t.Fatalf("Convert() error = %v, want a *RequiredError", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(tt.destination, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", tt.destination, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithMaxBodySize(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	type upload struct {
+		File *File `file:"binary"`
+	}
+
+	type form struct {
+		Name string `form:"name"`
+	}
+
+	type trailer struct {
+		Checksum string `trailer:"X-Checksum"`
+	}
+
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentType   string
+		unknownLength bool
+		destination   any
+		wantErr       bool
+	}{
+		{
+			name:        "json within limit",
+			body:        strings.NewReader(`{"name":"ada"}`),
+			contentType: "application/json",
+			destination: &payload{},
+		},
+		{
+			name:        "json over limit",
+			body:        strings.NewReader(`{"name":"ada lovelace"}`),
+			contentType: "application/json",
+			destination: &payload{},
+			wantErr:     true,
+		},
+		{
+			name:          "json over limit with unknown length",
+			body:          strings.NewReader(`{"name":"ada lovelace"}`),
+			contentType:   "application/json",
+			unknownLength: true,
+			destination:   &payload{},
+			wantErr:       true,
+		},
+		{
+			name:          "binary over limit",
+			body:          strings.NewReader("0123456789abcdefghij"),
+			contentType:   "application/octet-stream",
+			unknownLength: true,
+			destination:   &upload{},
+			wantErr:       true,
+		},
+		{
+			name:        "form over limit",
+			body:        strings.NewReader("name=ada+lovelace+byron"),
+			contentType: "application/x-www-form-urlencoded",
+			destination: &form{},
+			wantErr:     true,
+		},
+		{
+			name:        "binary without body",
+			destination: &upload{},
+		},
+		{
+			name:        "trailer without body",
+			destination: &trailer{},
+		},
+		{
+			name:        "json with no body",
+			body:        http.NoBody,
+			contentType: "application/json",
+			destination: &payload{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mustNewRequest(t, tt.body)
+			request.Header.Set("Content-Type", tt.contentType)
+			request.Header.Set("Content-Disposition", `attachment; filename="a.bin"`)
+
+			if tt.unknownLength {
+				request.ContentLength = -1
+			}
+
+			err := NewDecoder(WithMaxBodySize(16)).Decode(request, tt.destination)
+			if tt.wantErr != errors.Is(err, ErrBodyTooLarge) {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+		})
+	}
+}
+
+// mustNewRequest returns a client POST request carrying body, which may be
+// nil.
+func mustNewRequest(t *testing.T, body io.Reader) *http.Request {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, "/", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return request
+}
+
+// compress returns content compressed for the given Content-Encoding, gzip or
+// deflate.
+func compress(t *testing.T, encoding, content string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	var writer io.WriteCloser = gzip.NewWriter(&buf)
+	if encoding == "deflate" {
+		writer = zlib.NewWriter(&buf)
+	}
+
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestConvertCompressedBody(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	// 64KB of whitespace compress to a few dozen bytes.
+	bomb := `{"name":"ada"` + strings.Repeat(" ", 64<<10) + `}`
+
+	tests := []struct {
+		name     string
+		encoding string
+		content  string
+		opts     []Option
+		want     string
+		wantErr  error
+	}{
+		{name: "gzip", encoding: "gzip", content: `{"name":"ada"}`, want: "ada"},
+		{name: "x-gzip", encoding: "x-gzip", content: `{"name":"ada"}`, want: "ada"},
+		{name: "deflate", encoding: "deflate", content: `{"name":"ada"}`, want: "ada"},
+		{name: "within decompressed limit", encoding: "gzip", content: bomb, opts: []Option{WithMaxDecompressedSize(128 << 10)}, want: "ada"},
+		{name: "gzip bomb", encoding: "gzip", content: bomb, opts: []Option{WithMaxDecompressedSize(1 << 10)}, wantErr: ErrBodyTooLarge},
+		{name: "deflate bomb", encoding: "deflate", content: bomb, opts: []Option{WithMaxDecompressedSize(1 << 10)}, wantErr: ErrBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newJSONRequest("/", compress(t, strings.TrimPrefix(tt.encoding, "x-"), tt.content))
+			request.Header.Set("Content-Encoding", tt.encoding)
+
+			var got payload
+
+			err := NewDecoder(tt.opts...).Decode(request, &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && got.Name != tt.want {
+				t.Errorf("Name = %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package http2struct
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Converter converts a single request value into a value of the type it is
+// registered for.
+type Converter func(value string) (any, error)
+
+var (
+	// convertersMu serializes registrations, which replace converters
+	// rather than modify it, so that lookups need no lock.
+	convertersMu sync.Mutex
+
+	// converters holds the map of field types to the Converter registered
+	// for them.
+	converters atomic.Pointer[map[reflect.Type]Converter]
+
+	// convertersVersion counts the changes to converters, so that plans
+	// built before a registration are not used after it.
+	convertersVersion atomic.Uint64
+)
+
+// RegisterConverter registers converter for fields, slice elements and map
+// values of type t, such as a money type from a package that cannot implement
+// encoding.TextUnmarshaler. Registering a type that already has a converter
+// replaces it, and a nil converter removes it.
+//
+// A registered converter takes precedence over encoding.TextUnmarshaler and
+// the built-in conversions, and struct types with a converter are converted
+// from a single value instead of mapped field by field.
+func RegisterConverter(t reflect.Type, converter Converter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+
+	registered := map[reflect.Type]Converter{}
+	if current := converters.Load(); current != nil {
+		registered = maps.Clone(*current)
+	}
+
+	delete(registered, t)
+
+	if converter != nil {
+		registered[t] = converter
+	}
+
+	// The version changes after the converters, so that a plan built for
+	// the new version sees them.
+	converters.Store(&registered)
+	convertersVersion.Add(1)
+}
+
+// lookupConverter returns the Converter registered for t, if any.
+func lookupConverter(t reflect.Type) (Converter, bool) {
+	registered := converters.Load()
+	if registered == nil {
+		return nil, false
+	}
+
+	converter, ok := (*registered)[t]
+
+	return converter, ok
+}
+
+// convertRegistered sets field to the result of converter for value.
+func convertRegistered(field reflect.Value, fieldType reflect.Type, converter Converter, value string) error {
+	result, err := converter(value)
+	if err != nil {
+		return fmt.Errorf("failed to convert value to %q: %w", fieldType.String(), err)
+	}
+
+	v := reflect.ValueOf(result)
+
+	switch {
+	case !v.IsValid():
+		field.Set(reflect.Zero(fieldType))
+	case v.Type().AssignableTo(fieldType):
+		field.Set(v)
+	case v.Type().ConvertibleTo(fieldType):
+		field.Set(v.Convert(fieldType))
+	default:
+		return fmt.Errorf("converter for %q returned %q", fieldType.String(), v.Type().String())
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package http2struct
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// money is a struct type converted by registered converters, holding an
+// amount in cents.
+type money struct {
+	cents int64
+}
+
+// parseMoney parses amounts such as "12.34" into money.
+func parseMoney(value string) (any, error) {
+	whole, fraction, _ := strings.Cut(value, ".")
+
+	cents, err := strconv.ParseInt(whole+(fraction + "00")[:2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", value)
+	}
+
+	return money{cents: cents}, nil
+}
+
+// label implements encoding.TextUnmarshaler, which registered converters take
+// precedence over.
+type label string
+
+func (l *label) UnmarshalText(text []byte) error {
+	*l = label("text:" + string(text))
+
+	return nil
+}
+
+func TestRegisterConverter(t *testing.T) {
+	type price struct {
+		Price  money   `query:"price"`
+		Limits []money `query:"limits"`
+		Max    *money  `query:"max"`
+		Label  label   `query:"label"`
+	}
+
+	// Before registration money is a struct that query values cannot fill.
+	// The plan cached by this decode must not outlive the registration.
+	decoder := NewDecoder()
+
+	var before price
+	if err := decoder.Decode(httptest.NewRequest(http.MethodGet, "/", nil), &before); !errors.Is(err, ErrUnsupportedKind) {
+		t.Fatalf("Decode() error = %v, want %v", err, ErrUnsupportedKind)
+	}
+
+	stale, _ := decoder.plans.Load(reflect.TypeOf(price{}))
+
+	moneyType := reflect.TypeOf(money{})
+	labelType := reflect.TypeOf(label(""))
+
+	RegisterConverter(moneyType, parseMoney)
+	RegisterConverter(labelType, func(value string) (any, error) { return label("registered:" + value), nil })
+
+	t.Cleanup(func() {
+		RegisterConverter(moneyType, nil)
+		RegisterConverter(labelType, nil)
+	})
+
+	tests := []struct {
+		name    string
+		target  string
+		want    price
+		wantErr bool
+	}{
+		{
+			name:   "registered",
+			target: "/?price=12.34&limits=1,2.5&max=100&label=a",
+			want:   price{Price: money{1234}, Limits: []money{{100}, {250}}, Max: &money{10000}, Label: "registered:a"},
+		},
+		{
+			name:    "invalid value",
+			target:  "/?price=abc",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got price
+
+			err := decoder.Decode(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The plan rebuilt after the registration replaces the stale one.
+	if p, _ := decoder.plans.Load(reflect.TypeOf(price{})); p == stale {
+		t.Error("plan built before RegisterConverter() still cached")
+	}
+}
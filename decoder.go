@@ -0,0 +1,1429 @@
+package http2struct
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxMemory is the number of bytes of a multipart form kept in memory
+// when no WithMaxMemory option is given.
+const defaultMaxMemory = 32 << 20
+
+// defaultDecoder is the Decoder used by Convert.
+var defaultDecoder = NewDecoder()
+
+// Decoder maps HTTP requests into structs using a fixed configuration.
+// A Decoder is safe for concurrent use by multiple goroutines.
+type Decoder struct {
+	maxMemory            int64
+	maxBodySize          int64
+	maxDecompressedSize  int64
+	sniffContentType     bool
+	collectErrors        bool
+	caseInsensitiveKeys  bool
+	extendedBoolLiterals bool
+	location             *time.Location
+	bodyRoot             string
+	merge                bool
+	trimSpace            bool
+	reusableBody         bool
+	emptyPointers        bool
+	emptyValues          EmptyMode
+	disallowUnknownQuery bool
+	strictTags           bool
+	bodyMethods          []string // Methods whose bodies are decoded, or nil for all
+	validator            func(any) error
+	contextKeys          map[string]any    // Context keys of context tag names, when not a ContextKey
+	tagNames             map[string]string // Tag name of each source, when not the source itself
+	bodyDecoders         map[string]BodyDecoder
+	plans                sync.Map // map[reflect.Type]*typePlan
+	patterns             sync.Map // map[string]*regexp.Regexp
+}
+
+// Option configures a Decoder.
+type Option func(*Decoder)
+
+// WithMaxMemory sets the maximum number of bytes of a multipart form that are
+// kept in memory while parsing; the remainder is stored on disk in temporary
+// files. The default is 32 MiB.
+func WithMaxMemory(maxMemory int64) Option {
+	return func(d *Decoder) {
+		d.maxMemory = maxMemory
+	}
+}
+
+// WithMaxBodySize limits the number of bytes read from a request body, as sent
+// on the wire, by body decoders, form parsing and binary file fields. Requests
+// whose body exceeds the limit fail with ErrBodyTooLarge, which callers
+// typically answer with 413 Request Entity Too Large. A limit of zero, the
+// default, disables the check.
+func WithMaxBodySize(maxBodySize int64) Option {
+	return func(d *Decoder) {
+		d.maxBodySize = maxBodySize
+	}
+}
+
+// WithEmptyPointers makes pointer fields whose key is present with an empty
+// value, as in "?name=", point to the zero value instead of staying nil, so
+// that "set to empty" can be told apart from "not provided". Absent keys still
+// leave pointers nil, and fields tagged `required:"true"` still reject empty
+// values.
+func WithEmptyPointers() Option {
+	return func(d *Decoder) {
+		d.emptyPointers = true
+	}
+}
+
+// EmptyMode selects how Decoders created with WithEmptyValues handle keys
+// present with an empty value, as in "?age=".
+type EmptyMode int
+
+const (
+	// EmptySkip, the default, leaves fields zero for empty values, or sets
+	// them to their `default` tag unless they are pointers, which stay nil.
+	EmptySkip EmptyMode = iota
+
+	// EmptyError rejects empty values with an error wrapping ErrEmptyValue,
+	// except for string fields, whatever their `default` tag.
+	EmptyError
+
+	// EmptyDefault treats empty values as absent, so that every field,
+	// pointers included, is set to its `default` tag.
+	EmptyDefault
+)
+
+// WithEmptyValues sets how keys present with an empty value are handled, as
+// described by mode. Fields tagged `required:"true"` reject empty values with a
+// *RequiredError whatever the mode, and absent keys are not affected.
+func WithEmptyValues(mode EmptyMode) Option {
+	return func(d *Decoder) {
+		d.emptyValues = mode
+	}
+}
+
+// WithReusableBody buffers the request body in memory before decoding it and
+// leaves request.Body reading the buffered bytes from the start afterwards, so
+// that middleware and handlers can read the body again after Decode. The
+// buffered body counts against WithMaxBodySize, and request.GetBody returns
+// further copies. Bodies sent with a Content-Encoding are buffered, and left,
+// decompressed.
+func WithReusableBody() Option {
+	return func(d *Decoder) {
+		d.reusableBody = true
+	}
+}
+
+// WithMaxDecompressedSize limits the number of bytes read from a request body
+// sent with a gzip or deflate Content-Encoding once it is decompressed. Reading
+// past the limit fails with ErrBodyTooLarge, which guards against
+// decompression bombs. A limit of zero, the default, disables the check.
+func WithMaxDecompressedSize(maxDecompressedSize int64) Option {
+	return func(d *Decoder) {
+		d.maxDecompressedSize = maxDecompressedSize
+	}
+}
+
+// WithContentSniffing makes the `accept` tag of file fields also check the
+// content type detected from the first bytes of each file with
+// http.DetectContentType, in addition to the type declared by the client.
+func WithContentSniffing() Option {
+	return func(d *Decoder) {
+		d.sniffContentType = true
+	}
+}
+
+// WithCollectErrors makes the Decoder keep going when a field fails to decode
+// and return an Errors value listing every failure, including the body
+// decoding error, so that all invalid fields can be reported at once. By
+// default decoding stops at the first error.
+func WithCollectErrors() Option {
+	return func(d *Decoder) {
+		d.collectErrors = true
+	}
+}
+
+// WithCaseInsensitiveKeys makes query and form keys match their tags
+// regardless of case, so `query:"page"` also reads "?Page=2". When a request
+// carries several keys differing only by case, such as "page" and "Page", the
+// one sorting first byte-wise wins and the others are ignored. Keys captured
+// into map fields are lowercased.
+func WithCaseInsensitiveKeys() Option {
+	return func(d *Decoder) {
+		d.caseInsensitiveKeys = true
+	}
+}
+
+// WithExtendedBoolLiterals makes bool fields also accept "on", "off", "yes",
+// "no", "y" and "n" in any case, besides the literals of strconv.ParseBool, so
+// that HTML checkboxes, which submit "on", map to true.
+func WithExtendedBoolLiterals() Option {
+	return func(d *Decoder) {
+		d.extendedBoolLiterals = true
+	}
+}
+
+// WithLocation sets the location in which time.Time fields are parsed when
+// their layout carries no time zone, and in which Unix timestamps are
+// returned. The default, also used for a nil location, is UTC.
+func WithLocation(location *time.Location) Option {
+	return func(d *Decoder) {
+		if location == nil {
+			location = time.UTC
+		}
+
+		d.location = location
+	}
+}
+
+// WithMerge makes the Decoder overlay request values onto the destination
+// instead of resetting its fields first, so that values set before decoding
+// survive unless the request provides a non-empty value for them. Default tags
+// only apply to fields that are still zero.
+func WithMerge() Option {
+	return func(d *Decoder) {
+		d.merge = true
+	}
+}
+
+// WithContextKey makes fields tagged `context:"name"` read the request context
+// value stored under key, so that keys defined by existing middleware can be
+// used instead of ContextKey(name).
+func WithContextKey(name string, key any) Option {
+	return func(d *Decoder) {
+		if d.contextKeys == nil {
+			d.contextKeys = make(map[string]any)
+		}
+
+		d.contextKeys[name] = key
+	}
+}
+
+// WithTrimSpace makes the Decoder trim leading and trailing whitespace from
+// form, header, query, path, cookie and other string values before converting
+// them, so that " 42 " converts to an int and string fields are stored
+// trimmed. Values left empty by trimming count as empty.
+func WithTrimSpace() Option {
+	return func(d *Decoder) {
+		d.trimSpace = true
+	}
+}
+
+// WithValidator sets a function that validates each destination once every
+// field has been populated, such as the Struct method of a
+// go-playground/validator Validate. Its error is returned verbatim. It runs
+// before the Validate method of destinations implementing Validator.
+func WithValidator(validator func(any) error) Option {
+	return func(d *Decoder) {
+		d.validator = validator
+	}
+}
+
+// WithTagName makes the Decoder read the fields of source, one of "form",
+// "file", "header", "query", "path", "cookie", "meta", "auth", "context" or
+// "body", from struct tags named name instead, such as `param:"id"` for path
+// values with WithTagName("path", "param"). Body fields keep using the tags
+// understood by their body decoder, such as json and xml.
+func WithTagName(source, name string) Option {
+	return func(d *Decoder) {
+		if d.tagNames == nil {
+			d.tagNames = make(map[string]string)
+		}
+
+		d.tagNames[source] = name
+	}
+}
+
+// WithBodyDecoder sets the BodyDecoder used by this Decoder for request bodies
+// with the given base media type, taking precedence over decoders registered
+// with RegisterBodyDecoder.
+func WithBodyDecoder(mediaType string, decoder BodyDecoder) Option {
+	return func(d *Decoder) {
+		if d.bodyDecoders == nil {
+			d.bodyDecoders = make(map[string]BodyDecoder)
+		}
+
+		d.bodyDecoders[strings.ToLower(strings.TrimSpace(mediaType))] = decoder
+	}
+}
+
+// WithBodyRoot makes the Decoder decode only the member named root of a JSON
+// object body into the destination, as in {"data": {...}} envelopes. Bodies
+// without that member fail to decode. Bodies of other media types, such as XML
+// or YAML, are decoded whole.
+func WithBodyRoot(root string) Option {
+	return func(d *Decoder) {
+		d.bodyRoot = root
+	}
+}
+
+// WithBodyMethods restricts body decoding to requests with one of the given
+// methods, such as "POST", "PUT" and "PATCH", so that a body sent with any
+// other method is never parsed into json or xml fields. Form, file and raw
+// body fields are not affected. By default bodies are decoded for every
+// method.
+func WithBodyMethods(methods ...string) Option {
+	return func(d *Decoder) {
+		d.bodyMethods = make([]string, len(methods))
+
+		for i, method := range methods {
+			d.bodyMethods[i] = strings.ToUpper(method)
+		}
+	}
+}
+
+// WithDisallowUnknownQuery makes Decode and DecodeValues fail with an error
+// wrapping ErrUnknownQuery when the query holds parameters that no field
+// consumes, catching typos such as "?pge=2". Parameters captured by map fields,
+// including `query:"*"` ones, are consumed. Unknown parameters are ignored by
+// default.
+func WithDisallowUnknownQuery() Option {
+	return func(d *Decoder) {
+		d.disallowUnknownQuery = true
+	}
+}
+
+// WithDisallowUnknownFields makes JSON bodies containing object keys that do
+// not match any destination field fail to decode, like
+// json.Decoder.DisallowUnknownFields. Unknown keys are ignored by default.
+func WithDisallowUnknownFields() Option {
+	return WithBodyDecoder("application/json", decodeStrictJSON)
+}
+
+// WithStrictTags makes Decode fail with a *TagConflictError when a field of
+// the destination carries more than one source tag, such as both query and
+// header, instead of populating it from the source that takes precedence.
+func WithStrictTags() Option {
+	return func(d *Decoder) {
+		d.strictTags = true
+	}
+}
+
+// NewDecoder returns a Decoder configured by the given options.
+func NewDecoder(opts ...Option) *Decoder {
+	d := &Decoder{
+		maxMemory: defaultMaxMemory,
+		location:  time.UTC,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Decode maps data from an HTTP request into a struct. See Convert for the
+// supported struct tags and field types. Pointers to slices and maps only
+// receive the decoded body, such as a JSON array of objects or a JSON object
+// decoded into a map[string]any. Maps are left empty when there is no body,
+// and a body that no body decoder handles, such as a form, is an error.
+func (d *Decoder) Decode(request *http.Request, destination any) error {
+	return d.decode(request, destination, nil)
+}
+
+// DecodeWithReport works like Decode and also returns a Report of the fields
+// that received a value from the request. See ConvertWithReport.
+func (d *Decoder) DecodeWithReport(request *http.Request, destination any) (Report, error) {
+	var report Report
+
+	err := d.decode(request, destination, &report)
+
+	return report, err
+}
+
+// decode implements Decode, adding the fields populated from the request to
+// report when it is not nil.
+func (d *Decoder) decode(request *http.Request, destination any, report *Report) error {
+	if request == nil {
+		return fmt.Errorf("request cannot be nil")
+	}
+
+	if u, ok := destination.(RequestUnmarshaler); ok {
+		if err := u.UnmarshalRequest(request); err != nil {
+			return fmt.Errorf("failed to unmarshal request: %w", err)
+		}
+
+		return d.validate(destination)
+	}
+
+	if t := reflect.TypeOf(destination); t != nil && t.Kind() == reflect.Pointer && (t.Elem().Kind() == reflect.Slice || t.Elem().Kind() == reflect.Map) {
+		return d.decodeBody(request, destination)
+	}
+
+	plan, err := d.destinationPlan(destination)
+	if err != nil {
+		return err
+	}
+
+	var (
+		encoded *countingReader
+		state   *decodeState
+	)
+
+	// Trailer fields read the body to its end, within the same limits.
+	if plan.body || plan.form || plan.binary || plan.raw || plan.trailer {
+		// Binary file fields receive compressed bodies as sent unless
+		// they ask for the decompressed content, whatever other fields
+		// the struct has.
+		decompress := plan.body || plan.form || plan.raw
+		if plan.binary {
+			decompress = plan.decompress
+		}
+
+		var stop func()
+
+		encoded, stop, err = d.prepareBody(request, decompress)
+		if err != nil {
+			return err
+		}
+
+		// A body streamed by a field is still read after Decode returns,
+		// so it keeps being closed once the request is cancelled.
+		defer func() {
+			if state == nil || !state.streamed {
+				stop()
+			}
+		}()
+
+		if d.reusableBody {
+			defer rewindBody(request)
+		}
+	}
+
+	var raw []byte
+
+	// Reports carry the raw body, and need it to tell which keys a JSON
+	// body carries.
+	reportBody := report != nil && plan.body && d.decodesBody(request)
+	reportJSON := reportBody && d.isJSONBody(request)
+
+	// Requests without a body, such as client requests built with a nil
+	// body, leave raw nil, so that required raw body fields are rejected.
+	if (plan.raw || reportBody) && hasBody(request) {
+		raw, err = io.ReadAll(request.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+
+		// Body decoders read the buffered copy, so the body is only read once.
+		request.Body = readCloser{Reader: bytes.NewReader(raw), Closer: request.Body}
+	}
+
+	if reportBody {
+		report.Body = raw
+	}
+
+	var errs Errors
+
+	if plan.body {
+		if err := d.convertBody(request, destination); err != nil {
+			if err := d.collect(&errs, &ConvertError{Source: "body", Err: err}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if plan.form {
+		if err := d.parseForm(request); err != nil {
+			return err
+		}
+	}
+
+	state = &decodeState{
+		request: request,
+		query:   d.foldValues(request.URL.Query()),
+		form:    d.foldValues(request.PostForm),
+		raw:     raw,
+		encoded: encoded,
+		report:  report,
+	}
+
+	if reportJSON {
+		report.addJSON(reflect.TypeOf(destination).Elem(), raw, d.bodyRoot, d.tagNames)
+	}
+
+	if err := d.decodeFields(state, reflect.ValueOf(destination).Elem(), plan, ""); err != nil {
+		if err := d.collect(&errs, err); err != nil {
+			return err
+		}
+	}
+
+	if err := d.checkUnknownQuery(state); err != nil {
+		if err := d.collect(&errs, err); err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return d.validate(destination)
+}
+
+// decodeBody decodes the request body, such as a JSON array of objects or a
+// JSON object, into a pointer to a slice or map. Other sources do not apply to
+// such destinations, so bodies no decoder understands are rejected. Maps are
+// left empty rather than nil when there is no body.
+func (d *Decoder) decodeBody(request *http.Request, destination any) error {
+	v := reflect.ValueOf(destination)
+	isMap := v.Elem().Kind() == reflect.Map
+
+	// Slices and maps have nothing else to receive the request, so a body
+	// no decoder understands, such as a form, is an error rather than an
+	// empty result.
+	if request.ContentLength != 0 && (d.bodyMethods == nil || slices.Contains(d.bodyMethods, request.Method)) && !d.decodesBody(request) {
+		contentType := mediaType(request.Header.Get("Content-Type"))
+
+		return &ConvertError{Source: "body", Err: fmt.Errorf("unsupported content type %q for %q destination", contentType, v.Elem().Type().String())}
+	}
+
+	_, stop, err := d.prepareBody(request, true)
+	if err != nil {
+		return err
+	}
+
+	defer stop()
+
+	if d.reusableBody {
+		defer rewindBody(request)
+	}
+
+	if err := d.convertBody(request, destination); err != nil {
+		return &ConvertError{Source: "body", Err: err}
+	}
+
+	if isMap && v.Elem().IsNil() {
+		v.Elem().Set(reflect.MakeMap(v.Elem().Type()))
+	}
+
+	return nil
+}
+
+// DecodeValues maps values into the fields of a struct tagged with query, as if
+// they were the query parameters of a request. Fields with a source tag read
+// the query parameters it lists, and fields with other source tags are left
+// untouched.
+func (d *Decoder) DecodeValues(values url.Values, destination any) error {
+	plan, err := d.destinationPlan(destination)
+	if err != nil {
+		return err
+	}
+
+	state := &decodeState{
+		query:     d.foldValues(values),
+		queryOnly: true,
+	}
+
+	if err := d.decodeFields(state, reflect.ValueOf(destination).Elem(), plan, ""); err != nil {
+		return err
+	}
+
+	if err := d.checkUnknownQuery(state); err != nil {
+		return err
+	}
+
+	return d.validate(destination)
+}
+
+// checkUnknownQuery returns an error listing the query parameters that no
+// field consumed, when d was created with WithDisallowUnknownQuery.
+func (d *Decoder) checkUnknownQuery(state *decodeState) error {
+	if !d.disallowUnknownQuery {
+		return nil
+	}
+
+	var unknown []string
+
+	for key := range state.query {
+		if state.queryKeys[key] || slices.ContainsFunc(state.queryPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(key, prefix)
+		}) {
+			continue
+		}
+
+		unknown = append(unknown, fmt.Sprintf("%q", key))
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	slices.Sort(unknown)
+
+	return fmt.Errorf("%w %s", ErrUnknownQuery, strings.Join(unknown, ", "))
+}
+
+// validate runs the validator configured with WithValidator, then the
+// Validate method of destination if it implements Validator.
+func (d *Decoder) validate(destination any) error {
+	if d.validator != nil {
+		if err := d.validator(destination); err != nil {
+			return err
+		}
+	}
+
+	v, ok := destination.(Validator)
+	if !ok {
+		return nil
+	}
+
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("failed to validate destination: %w", err)
+	}
+
+	return nil
+}
+
+// destinationPlan checks that destination is a pointer to a struct and
+// returns the plan of the struct type.
+func (d *Decoder) destinationPlan(destination any) (*typePlan, error) {
+	destinationType := reflect.TypeOf(destination)
+
+	if destinationType == nil {
+		return nil, fmt.Errorf("destination cannot be nil")
+	}
+
+	if destinationType.Kind() != reflect.Ptr {
+		return nil, ErrNotPointer
+	}
+
+	destinationType = destinationType.Elem()
+
+	if destinationType.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	return d.plan(destinationType), nil
+}
+
+// decodeState holds the per-request data shared by all fields of a Decode
+// call.
+type decodeState struct {
+	request *http.Request
+	query   url.Values      // Parsed URL query, computed once per request
+	form    url.Values      // Parsed form values of the request body
+	raw     []byte          // Raw body, read only when a field uses the body tag
+	encoded *countingReader // Counter of the body as sent, when it was decompressed
+
+	queryOnly bool // Whether only query fields are populated, as by DecodeValues
+	streamed  bool // Whether a field received the body itself to read later
+	drained   bool // Whether the body was read to the end for its trailers
+
+	queryKeys     map[string]bool // Query keys consumed by fields
+	queryPrefixes []string        // Prefixes of the query keys consumed by map fields
+
+	report  *Report // Report of populated fields, when requested
+	path    string  // Path of the nested struct being decoded, ending in a dot
+	name    string  // Name within its source of the value of the last decoded field
+	present bool    // Whether the source provided the value of the last decoded field
+	filled  bool    // Whether the source provided the value of any decoded field
+}
+
+// useQuery records that a field consumed the query key, for
+// WithDisallowUnknownQuery.
+func (s *decodeState) useQuery(key string) {
+	if s.queryKeys == nil {
+		s.queryKeys = map[string]bool{}
+	}
+
+	s.queryKeys[key] = true
+}
+
+// drainBody reads the rest of the body, so that its trailers are set, unless
+// a field received the body to stream it.
+func (s *decodeState) drainBody() error {
+	if s.request == nil || s.request.Body == nil || s.streamed || s.drained {
+		return nil
+	}
+
+	s.drained = true
+
+	if _, err := io.Copy(io.Discard, s.request.Body); err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+
+	return nil
+}
+
+// found records whether the source of the field being decoded provides its
+// value, under the given name, for the report.
+func (s *decodeState) found(name string, present bool) {
+	s.name, s.present = name, present
+}
+
+// decodeFields populates the fields of the struct v described by plan. Query
+// and form names are prefixed with prefix, which is how the fields of nested
+// structs map keys such as "address.city". Decoders created with
+// WithCollectErrors keep going after a field fails and return every error as
+// Errors.
+func (d *Decoder) decodeFields(state *decodeState, v reflect.Value, plan *typePlan, prefix string) error {
+	if d.strictTags && len(plan.conflicts) > 0 {
+		return plan.conflicts[0]
+	}
+
+	var errs Errors
+
+	// Trailers are only set once the body has been read to the end, so
+	// fields reading them come after the fields that consume the body.
+	for _, trailers := range []bool{false, true} {
+		if trailers && plan.trailer {
+			if err := state.drainBody(); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range plan.fields {
+			if f.readsTrailer() != trailers {
+				continue
+			}
+
+			path := state.path
+			state.present = false
+
+			if f.nested {
+				state.path = path + f.field.Name + "."
+			}
+
+			err := d.decodeField(state, v.Field(f.index), f, prefix)
+			state.path = path
+
+			if message, ok := f.field.Tag.Lookup("msg"); ok && err != nil && f.embed == nil && !f.nested {
+				err = &MessageError{Field: f.field.Name, Message: message, Err: err}
+			}
+
+			if err != nil {
+				if err := d.collect(&errs, err); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			state.filled = state.filled || state.present
+
+			if state.report != nil && state.present && f.embed == nil && !f.nested {
+				state.report.add(path+f.field.Name, f.source, state.name)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// collect appends err to errs, flattening nested Errors, when d collects
+// errors. Otherwise it returns err so that decoding stops.
+func (d *Decoder) collect(errs *Errors, err error) error {
+	if !d.collectErrors {
+		return err
+	}
+
+	if list, ok := err.(Errors); ok {
+		*errs = append(*errs, list...)
+
+		return nil
+	}
+
+	*errs = append(*errs, err)
+
+	return nil
+}
+
+// decodeField populates the field fieldValue described by f.
+func (d *Decoder) decodeField(state *decodeState, fieldValue reflect.Value, f fieldPlan, prefix string) error {
+	request, raw := state.request, state.raw
+	field, tag := f.field, f.name
+
+	if state.queryOnly && f.embed == nil && !f.readsQuery() {
+		return nil
+	}
+
+	if f.embed != nil {
+		return d.decodeEmbedded(state, fieldValue, f.embed, prefix)
+	}
+
+	// Request values carry no type to store in an interface, so such fields
+	// are left to the body decoder, or to context values, untouched.
+	if field.Type.Kind() == reflect.Interface && f.source != sourceContext && field.Type != readerType && field.Type != readCloserType {
+		return fmt.Errorf("%q type is not supported for %q field from %s: %w", field.Type.String(), field.Name, f.source, ErrUnsupportedKind)
+	}
+
+	// Nor can they fill the fields of struct elements, so slices of structs
+	// are left to the body decoder untouched, except for uploaded files.
+	if d.isStructSlice(field.Type) && f.source != sourceFile && f.source != sourceBinary && f.source != sourceContext {
+		return fmt.Errorf("%q type is not supported for %q field from %s: %w", field.Type.String(), field.Name, f.source, ErrUnsupportedKind)
+	}
+
+	if !d.merge {
+		fieldValue.SetZero()
+	}
+
+	if f.unmarshal {
+		return unmarshalField(state.request, fieldValue, field)
+	}
+
+	if f.nested {
+		return d.decodeNested(state, fieldValue, f, prefix+tag+".")
+	}
+
+	switch f.source {
+	case sourceForm:
+		if field.Type.Kind() == reflect.Map {
+			if err := d.convertMap(fieldValue, field.Type, field.Tag, state.form, d.foldKey(prefix), d.foldKey(tag)); err != nil {
+				return fieldError(field.Name, prefix+tag, "form", err)
+			}
+
+			state.found(prefix+tag, fieldValue.Len() > 0)
+
+			if fieldValue.Len() == 0 && isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "form", Name: prefix + tag}
+			}
+
+			return nil
+		}
+
+		key := prefix + tag
+		p, present := state.form[d.foldKey(key)]
+		state.found(key, present)
+
+		if err := d.convertField(fieldValue, field, "form", key, p, present); err != nil {
+			return fieldError(field.Name, key, "form", err)
+		}
+	case sourceFile:
+		// The meta option, as in `file:"upload,meta"`, leaves File.Content
+		// nil so that large uploads are never read into memory.
+		tag, option, _ := strings.Cut(tag, ",")
+		meta := option == "meta"
+
+		if option != "" && !meta {
+			return fmt.Errorf("unknown %q option in file tag of %q field", option, field.Name)
+		}
+
+		elementType := field.Type
+		if elementType.Kind() == reflect.Slice {
+			elementType = elementType.Elem()
+		}
+
+		stream := elementType == readCloserType || elementType == reflect.TypeOf(FileStream{}) || elementType == reflect.TypeOf(&FileStream{})
+
+		if (meta || !stream) && elementType != reflect.TypeOf(File{}) && elementType != reflect.TypeOf(&File{}) {
+			return fmt.Errorf("%q type is not supported for %q field: %w", fieldValue.Type().String(), field.Name, ErrUnsupportedKind)
+		}
+
+		var fileHeaders []*multipart.FileHeader
+
+		if request.MultipartForm != nil {
+			fileHeaders = formFiles(request.MultipartForm, tag)
+		}
+
+		if len(fileHeaders) == 0 {
+			if isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "file", Name: tag}
+			}
+
+			return nil
+		}
+
+		state.found(tag, true)
+
+		if field.Type.Kind() != reflect.Slice {
+			fileHeaders = fileHeaders[:1]
+		}
+
+		maxSize, err := fileMaxSize(field)
+		if err != nil {
+			return fmt.Errorf("failed to parse maxsize tag for %q field: %w", field.Name, err)
+		}
+
+		files := reflect.MakeSlice(reflect.SliceOf(elementType), 0, len(fileHeaders))
+
+		for _, fileHeader := range fileHeaders {
+			if maxSize > 0 && fileHeader.Size > maxSize {
+				closeStreams(files)
+
+				return &FileSizeError{Field: field.Name, Name: tag, MaxSize: maxSize}
+			}
+
+			if stream {
+				s, err := d.openFile(field, tag, fileHeader)
+				if err != nil {
+					closeStreams(files)
+
+					return err
+				}
+
+				switch elementType {
+				case readCloserType:
+					files = reflect.Append(files, reflect.ValueOf(&s.Reader).Elem())
+				case reflect.TypeOf(&FileStream{}):
+					files = reflect.Append(files, reflect.ValueOf(&s))
+				default:
+					files = reflect.Append(files, reflect.ValueOf(s))
+				}
+
+				continue
+			}
+
+			f, err := d.loadFile(field, tag, fileHeader, meta)
+			if err != nil {
+				return err
+			}
+
+			if elementType.Kind() == reflect.Pointer {
+				files = reflect.Append(files, reflect.ValueOf(&f))
+
+				continue
+			}
+
+			files = reflect.Append(files, reflect.ValueOf(f))
+		}
+
+		if field.Type.Kind() == reflect.Slice {
+			fieldValue.Set(files)
+
+			return nil
+		}
+
+		fieldValue.Set(files.Index(0))
+	case sourceBinary:
+		if field.Type == readerType || field.Type == readCloserType {
+			if request.ContentLength == 0 {
+				if isRequired(field) {
+					return &RequiredError{Field: field.Name, Source: "file", Name: tag}
+				}
+
+				return nil
+			}
+
+			state.found(tag, true)
+			state.streamed = true
+			fieldValue.Set(reflect.ValueOf(request.Body))
+
+			return nil
+		}
+
+		if field.Type.Kind() != reflect.Pointer && field.Type != reflect.TypeOf(File{}) {
+			return fmt.Errorf("%q type is not supported for %q field: %w", fieldValue.Type().String(), field.Name, ErrUnsupportedKind)
+		}
+
+		if field.Type.Kind() == reflect.Pointer && field.Type != reflect.TypeOf(&File{}) {
+			return fmt.Errorf("%q type is not supported for %q field: %w", fieldValue.Type().String(), field.Name, ErrUnsupportedKind)
+		}
+
+		// The Content-Length of chunked bodies is unknown, so emptiness is
+		// only decided once the body has been read.
+		filename := dispositionFilename(request.Header.Get("Content-Disposition"))
+		if filename == "" || request.Body == nil {
+			if isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "file", Name: tag}
+			}
+
+			return nil
+		}
+
+		maxSize, err := fileMaxSize(field)
+		if err != nil {
+			return fmt.Errorf("failed to parse maxsize tag for %q field: %w", field.Name, err)
+		}
+
+		if maxSize > 0 && request.ContentLength > maxSize {
+			return &FileSizeError{Field: field.Name, Name: tag, MaxSize: maxSize}
+		}
+
+		var body io.Reader = request.Body
+
+		if maxSize > 0 {
+			body = io.LimitReader(body, maxSize+1)
+		}
+
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return &ConvertError{Field: field.Name, Tag: tag, Source: "file", Err: fmt.Errorf("failed to read body: %w", err)}
+		}
+
+		if maxSize > 0 && int64(len(content)) > maxSize {
+			return &FileSizeError{Field: field.Name, Name: tag, MaxSize: maxSize}
+		}
+
+		if len(content) == 0 {
+			if isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "file", Name: tag}
+			}
+
+			return nil
+		}
+
+		state.found(tag, true)
+
+		f := File{
+			Name:        filename,
+			Size:        int64(len(content)),
+			ContentType: request.Header.Get("Content-Type"),
+			Content:     content,
+		}
+
+		if state.encoded != nil {
+			f.EncodedSize = state.encoded.n
+		}
+
+		if err := d.checkFileType(field, tag, f); err != nil {
+			return err
+		}
+
+		if field.Type.Kind() == reflect.Pointer {
+			fieldValue.Set(reflect.ValueOf(&f))
+
+			return nil
+		}
+
+		fieldValue.Set(reflect.ValueOf(f))
+	case sourceHeader:
+		if tag == "*" {
+			if err := d.convertHeaders(fieldValue, field, request.Header); err != nil {
+				return fieldError(field.Name, tag, "header", err)
+			}
+
+			state.found(tag, fieldValue.Len() > 0)
+
+			if fieldValue.Len() == 0 && isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "header", Name: tag}
+			}
+
+			return nil
+		}
+
+		h := request.Header.Values(tag)
+		state.found(tag, len(h) > 0)
+
+		if isList(field) {
+			h = splitList(h)
+		}
+
+		if err := d.convertField(fieldValue, field, "header", tag, h, len(h) > 0); err != nil {
+			return fieldError(field.Name, tag, "header", err)
+		}
+	case sourceTrailer:
+		t := request.Trailer.Values(tag)
+		state.found(tag, len(t) > 0)
+
+		if err := d.convertField(fieldValue, field, "trailer", tag, t, len(t) > 0); err != nil {
+			return fieldError(field.Name, tag, "trailer", err)
+		}
+	case sourceQuery:
+		if field.Type.Kind() == reflect.Map {
+			if err := d.convertMap(fieldValue, field.Type, field.Tag, state.query, d.foldKey(prefix), d.foldKey(tag)); err != nil {
+				return fieldError(field.Name, prefix+tag, "query", err)
+			}
+
+			consumed := prefix + tag + "["
+			if tag == "*" {
+				consumed = prefix
+			}
+
+			state.queryPrefixes = append(state.queryPrefixes, d.foldKey(consumed))
+
+			state.found(prefix+tag, fieldValue.Len() > 0)
+
+			if fieldValue.Len() == 0 && isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "query", Name: prefix + tag}
+			}
+
+			return nil
+		}
+
+		key := prefix + tag
+		q, present := state.query[d.foldKey(key)]
+
+		// Slices also take keys such as items[0] and items[1], when the
+		// key is not sent without an index.
+		if !present && field.Type.Kind() == reflect.Slice && !d.isScalar(field.Type) {
+			indexed, keys, err := indexedValues(state.query, d.foldKey(key))
+			if err != nil {
+				return fieldError(field.Name, key, "query", err)
+			}
+
+			if indexed != nil {
+				for _, k := range keys {
+					state.useQuery(k)
+				}
+
+				state.found(key, true)
+
+				if err := d.convertSlice(fieldValue, field.Type, field.Tag, indexed); err != nil {
+					return fieldError(field.Name, key, "query", err)
+				}
+
+				return nil
+			}
+		}
+
+		state.found(key, present)
+		state.useQuery(d.foldKey(key))
+
+		if err := d.convertField(fieldValue, field, "query", key, q, present); err != nil {
+			return fieldError(field.Name, key, "query", err)
+		}
+	case sourcePath:
+		v := request.PathValue(tag)
+
+		// Slices hold the segments of catch-all wildcards such as
+		// {path...}, unless the delim tag picks another separator.
+		if kind := field.Type.Kind(); (kind == reflect.Slice || kind == reflect.Array) && field.Type.Elem().Kind() != reflect.Uint8 {
+			if _, ok := field.Tag.Lookup("delim"); !ok {
+				field.Tag += ` delim:"/"`
+				v = strings.TrimPrefix(v, "/")
+			}
+		}
+
+		state.found(tag, v != "")
+
+		if err := d.convertField(fieldValue, field, "path", tag, []string{v}, v != ""); err != nil {
+			return fieldError(field.Name, tag, "path", err)
+		}
+	case sourceCookie:
+		var c []string
+
+		for _, cookie := range request.CookiesNamed(tag) {
+			c = append(c, cookie.Value)
+		}
+
+		state.found(tag, len(c) > 0)
+
+		if err := d.convertField(fieldValue, field, "cookie", tag, c, len(c) > 0); err != nil {
+			return fieldError(field.Name, tag, "cookie", err)
+		}
+	case sourceMeta:
+		value, ok := metaValues[tag]
+		if !ok {
+			return fmt.Errorf("unknown meta key %q for %q field", tag, field.Name)
+		}
+
+		v := value(request)
+		state.found(tag, v != "")
+
+		if err := d.convertField(fieldValue, field, "meta", tag, []string{v}, v != ""); err != nil {
+			return fieldError(field.Name, tag, "meta", err)
+		}
+	case sourceAuth:
+		value, ok := authValues[tag]
+		if !ok {
+			return fmt.Errorf("unknown auth key %q for %q field", tag, field.Name)
+		}
+
+		v := value(request)
+		state.found(tag, v != "")
+
+		if err := d.convertField(fieldValue, field, "auth", tag, []string{v}, v != ""); err != nil {
+			return fieldError(field.Name, tag, "auth", err)
+		}
+	case sourceContext:
+		var key any = ContextKey(tag)
+		if k, ok := d.contextKeys[tag]; ok {
+			key = k
+		}
+
+		value := request.Context().Value(key)
+		state.found(tag, value != nil)
+
+		if value != nil && reflect.TypeOf(value).AssignableTo(field.Type) {
+			fieldValue.Set(reflect.ValueOf(value))
+
+			return nil
+		}
+
+		s, ok := value.(string)
+		if value != nil && !ok {
+			err := fmt.Errorf("%q value is not assignable to %q", reflect.TypeOf(value).String(), field.Type.String())
+
+			return fieldError(field.Name, tag, "context", err)
+		}
+
+		if err := d.convertField(fieldValue, field, "context", tag, []string{s}, ok); err != nil {
+			return fieldError(field.Name, tag, "context", err)
+		}
+	case sourceFallback:
+		// Query parameters listed after the source that wins are still
+		// known to the field.
+		for _, ref := range f.sources {
+			if ref.source == sourceQuery {
+				state.useQuery(d.foldKey(prefix + ref.name))
+			}
+		}
+
+		for _, ref := range f.sources {
+			name := ref.name
+			if ref.source == sourceQuery || ref.source == sourceForm {
+				name = prefix + name
+			}
+
+			values, err := d.sourceValues(state, ref.source, name)
+			if err != nil {
+				return fmt.Errorf("failed to read source tag of %q field: %w", field.Name, err)
+			}
+
+			if len(values) == 0 || values[0] == "" {
+				continue
+			}
+
+			state.found(name, true)
+
+			if err := d.convertField(fieldValue, field, ref.source, name, values, true); err != nil {
+				return fieldError(field.Name, name, ref.source, err)
+			}
+
+			return nil
+		}
+
+		if err := d.convertField(fieldValue, field, sourceFallback, tag, nil, false); err != nil {
+			return fieldError(field.Name, tag, sourceFallback, err)
+		}
+	case sourceBody:
+		kind := field.Type.Kind()
+
+		if kind != reflect.String && (kind != reflect.Slice || field.Type.Elem().Kind() != reflect.Uint8) {
+			return fmt.Errorf("%q type is not supported for %q field: %w", fieldValue.Type().String(), field.Name, ErrUnsupportedKind)
+		}
+
+		if len(raw) == 0 {
+			if isRequired(field) {
+				return &RequiredError{Field: field.Name, Source: "body", Name: tag}
+			}
+
+			return nil
+		}
+
+		state.found(tag, true)
+
+		if kind == reflect.String {
+			fieldValue.SetString(string(raw))
+
+			return nil
+		}
+
+		fieldValue.SetBytes(raw)
+	}
+
+	return nil
+}
+
+// convertHeaders populates a map field tagged `header:"*"` with every header,
+// keyed by its canonical name. Fields of type map[string][]string or
+// http.Header receive the header lines as sent; other map values are converted
+// like header fields.
+func (d *Decoder) convertHeaders(fieldValue reflect.Value, field reflect.StructField, header http.Header) error {
+	if field.Type.Kind() != reflect.Map {
+		return fmt.Errorf("%q type is not supported for all headers: %w", field.Type.String(), ErrUnsupportedKind)
+	}
+
+	if len(header) == 0 {
+		return nil
+	}
+
+	if reflect.TypeOf(header).ConvertibleTo(field.Type) {
+		fieldValue.Set(reflect.ValueOf(header.Clone()).Convert(field.Type))
+
+		return nil
+	}
+
+	return d.convertMap(fieldValue, field.Type, field.Tag, url.Values(header), "", "*")
+}
+
+// sourceValues returns the values named name in source, for fields listing
+// several sources in a source tag.
+func (d *Decoder) sourceValues(state *decodeState, source, name string) ([]string, error) {
+	request := state.request
+
+	// Values decoded without a request only hold query parameters.
+	if state.queryOnly && source != sourceQuery {
+		return nil, nil
+	}
+
+	switch source {
+	case sourceHeader:
+		return request.Header.Values(name), nil
+	case sourceTrailer:
+		return request.Trailer.Values(name), nil
+	case sourceQuery:
+		return state.query[d.foldKey(name)], nil
+	case sourceForm:
+		return state.form[d.foldKey(name)], nil
+	case sourcePath:
+		return []string{request.PathValue(name)}, nil
+	case sourceCookie:
+		var values []string
+
+		for _, cookie := range request.CookiesNamed(name) {
+			values = append(values, cookie.Value)
+		}
+
+		return values, nil
+	case sourceMeta, sourceAuth:
+		values := metaValues
+		if source == sourceAuth {
+			values = authValues
+		}
+
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown %s key %q", source, name)
+		}
+
+		return []string{value(request)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported source %q", source)
+}
+
+// unmarshalField hands request to the UnmarshalRequest method of a field
+// implementing RequestUnmarshaler, allocating a nil pointer field first.
+func unmarshalField(request *http.Request, fieldValue reflect.Value, field reflect.StructField) error {
+	if fieldValue.Kind() == reflect.Pointer && fieldValue.IsNil() {
+		fieldValue.Set(reflect.New(field.Type.Elem()))
+	}
+
+	target := fieldValue
+	if !field.Type.Implements(requestUnmarshalerType) {
+		target = fieldValue.Addr()
+	}
+
+	if err := target.Interface().(RequestUnmarshaler).UnmarshalRequest(request); err != nil {
+		return fmt.Errorf("failed to unmarshal request into %q field: %w", field.Name, err)
+	}
+
+	return nil
+}
+
+// decodeNested populates a struct (or pointer to struct) field from the query
+// or form values whose keys start with prefix. A nil pointer is allocated only
+// when at least one such value is present.
+func (d *Decoder) decodeNested(state *decodeState, fieldValue reflect.Value, f fieldPlan, prefix string) error {
+	values := state.query
+	if f.source == sourceForm {
+		values = state.form
+	}
+
+	if fieldValue.Kind() != reflect.Pointer {
+		return d.decodeFields(state, fieldValue, d.plan(fieldValue.Type()), prefix)
+	}
+
+	if d.merge && !fieldValue.IsNil() {
+		return d.decodeFields(state, fieldValue.Elem(), d.plan(fieldValue.Type().Elem()), prefix)
+	}
+
+	present := false
+
+	for key := range values {
+		if strings.HasPrefix(key, d.foldKey(prefix)) {
+			present = true
+
+			break
+		}
+	}
+
+	if !present {
+		return nil
+	}
+
+	target := reflect.New(fieldValue.Type().Elem())
+
+	if err := d.decodeFields(state, target.Elem(), d.plan(target.Elem().Type()), prefix); err != nil {
+		return err
+	}
+
+	fieldValue.Set(target)
+
+	return nil
+}
+
+// decodeEmbedded populates the promoted fields of an embedded struct (or
+// pointer to struct) field. Values already decoded from the body are kept, and
+// a nil pointer is allocated only when the request provides one of its fields,
+// even when the value is the zero value, as in "?page=0".
+func (d *Decoder) decodeEmbedded(state *decodeState, fieldValue reflect.Value, plan *typePlan, prefix string) error {
+	if fieldValue.Kind() != reflect.Pointer {
+		return d.decodeFields(state, fieldValue, plan, prefix)
+	}
+
+	if !fieldValue.IsNil() {
+		return d.decodeFields(state, fieldValue.Elem(), plan, prefix)
+	}
+
+	filled := state.filled
+	state.filled = false
+
+	target := reflect.New(fieldValue.Type().Elem())
+	err := d.decodeFields(state, target.Elem(), plan, prefix)
+
+	if state.filled && err == nil {
+		fieldValue.Set(target)
+	}
+
+	state.filled = state.filled || filled
+
+	return err
+}
+
+// foldKey returns key lowercased when d matches keys case-insensitively.
+func (d *Decoder) foldKey(key string) string {
+	if !d.caseInsensitiveKeys {
+		return key
+	}
+
+	return strings.ToLower(key)
+}
+
+// foldValues returns values keyed by lowercased keys when d matches keys
+// case-insensitively. Of several keys differing only by case, the one sorting
+// first wins.
+func (d *Decoder) foldValues(values url.Values) url.Values {
+	if !d.caseInsensitiveKeys || values == nil {
+		return values
+	}
+
+	keys := slices.Sorted(maps.Keys(values))
+	folded := make(url.Values, len(values))
+
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+
+		if _, ok := folded[lower]; !ok {
+			folded[lower] = values[key]
+		}
+	}
+
+	return folded
+}
+
+// parseForm parses a multipart or URL-encoded request body once, so that form
+// and file fields can be read from request.PostForm and request.MultipartForm.
+// Requests with other content types are left untouched.
+func (d *Decoder) parseForm(request *http.Request) error {
+	base, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+
+	switch strings.TrimSpace(base) {
+	case "multipart/form-data":
+		if err := request.ParseMultipartForm(d.maxMemory); err != nil {
+			return fmt.Errorf("failed to parse request multipart form: %w", err)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := request.ParseForm(); err != nil {
+			return fmt.Errorf("failed to parse request form: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,915 @@
+package http2struct
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkRequest has about 15 tagged fields across several sources.
+type benchmarkRequest struct {
+	ID        uint64    `path:"id"`
+	Page      int       `query:"page" default:"1"`
+	Size      int       `query:"size" default:"20"`
+	Sort      []string  `query:"sort"`
+	Search    string    `query:"q"`
+	Active    bool      `query:"active"`
+	Ratio     float64   `query:"ratio"`
+	Since     time.Time `query:"since"`
+	Tags      []string  `query:"tags"`
+	Limit     *int      `query:"limit"`
+	Token     string    `header:"Authorization"`
+	RequestID string    `header:"X-Request-Id"`
+	Locale    string    `header:"Accept-Language"`
+	Session   string    `cookie:"session"`
+	Name      string    `json:"name"`
+}
+
+// newBenchmarkRequest returns a request filling every field of
+// benchmarkRequest.
+func newBenchmarkRequest() *http.Request {
+	request := newJSONRequest("/users/42?page=2&size=50&sort=name,id&q=ada&active=true&ratio=0.5&since=2024-01-02T15:04:05Z&tags=a&tags=b&limit=10", `{"name":"ada"}`)
+	request.SetPathValue("id", "42")
+	request.Header.Set("Authorization", "Bearer token")
+	request.Header.Set("X-Request-Id", "abc")
+	request.Header.Set("Accept-Language", "en")
+	request.AddCookie(&http.Cookie{Name: "session", Value: "s"})
+
+	return request
+}
+
+// BenchmarkDecode compares decoding with a reused Decoder, whose plan for the
+// destination type is cached, to a new Decoder building the plan every time.
+func BenchmarkDecode(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		decoder := NewDecoder()
+
+		b.ReportAllocs()
+
+		for b.Loop() {
+			var destination benchmarkRequest
+			if err := decoder.Decode(newBenchmarkRequest(), &destination); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for b.Loop() {
+			var destination benchmarkRequest
+			if err := NewDecoder().Decode(newBenchmarkRequest(), &destination); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWithLocation(t *testing.T) {
+	type appointment struct {
+		At time.Time `query:"at" timeformat:"2006-01-02T15:04"`
+	}
+
+	istanbul := time.FixedZone("Istanbul", 3*60*60)
+	newYork := time.FixedZone("New York", -5*60*60)
+
+	tests := []struct {
+		name string
+		opts []Option
+		want time.Time
+	}{
+		{name: "UTC by default", want: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)},
+		{name: "Istanbul", opts: []Option{WithLocation(istanbul)}, want: time.Date(2024, 5, 1, 9, 30, 0, 0, istanbul)},
+		{name: "New York", opts: []Option{WithLocation(newYork)}, want: time.Date(2024, 5, 1, 9, 30, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got appointment
+			if err := NewDecoder(tt.opts...).Decode(httptest.NewRequest(http.MethodGet, "/?at=2024-05-01T09:30", nil), &got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if !got.At.Equal(tt.want) || got.At.Location().String() != tt.want.Location().String() {
+				t.Errorf("At = %v, want %v", got.At, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDisallowUnknownQuery(t *testing.T) {
+	type list struct {
+		Page    int               `query:"page"`
+		Filters map[string]string `query:"filter"`
+		Tenant  string            `source:"header:X-Tenant,query:tenant"`
+	}
+
+	type catchAll struct {
+		Page int                 `query:"page"`
+		All  map[string][]string `query:"*"`
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		destination any
+		wantErr     bool
+	}{
+		{name: "known parameters", target: "/?page=2&filter[status]=open&tenant=acme", destination: &list{}},
+		{name: "stray parameter", target: "/?pge=2", destination: &list{}, wantErr: true},
+		{name: "stray next to known", target: "/?page=2&sort=name", destination: &list{}, wantErr: true},
+		{name: "catch-all suppresses", target: "/?page=2&pge=2&sort=name", destination: &catchAll{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewDecoder(WithDisallowUnknownQuery()).Decode(httptest.NewRequest(http.MethodGet, tt.target, nil), tt.destination)
+			if tt.wantErr != errors.Is(err, ErrUnknownQuery) {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeValuesDisallowUnknownQuery(t *testing.T) {
+	type list struct {
+		Page   int    `query:"page"`
+		Tenant string `source:"header:X-Tenant,query:tenant"`
+	}
+
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    list
+		wantErr bool
+	}{
+		{name: "source field", values: url.Values{"page": {"2"}, "tenant": {"acme"}}, want: list{Page: 2, Tenant: "acme"}},
+		{name: "stray parameter", values: url.Values{"tenat": {"acme"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got list
+
+			err := NewDecoder(WithDisallowUnknownQuery()).DecodeValues(tt.values, &got)
+			if tt.wantErr != errors.Is(err, ErrUnknownQuery) {
+				t.Fatalf("DecodeValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("DecodeValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithEmptyValues(t *testing.T) {
+	type plain struct {
+		Age int `query:"age"`
+	}
+
+	type defaulted struct {
+		Age int `query:"age" default:"18"`
+	}
+
+	type required struct {
+		Age int `query:"age" required:"true"`
+	}
+
+	type pointer struct {
+		Age *int `query:"age"`
+	}
+
+	type defaultedPointer struct {
+		Age *int `query:"age" default:"18"`
+	}
+
+	type text struct {
+		Name string `query:"age"`
+	}
+
+	zero, eighteen := 0, 18
+
+	// Each row decodes ?age= into its destination under every mode, as in
+	// the decision table of the README.
+	tests := []struct {
+		name        string
+		destination func() any
+		pointers    bool
+		want        map[EmptyMode]any
+	}{
+		{
+			name:        "int",
+			destination: func() any { return &plain{} },
+			want:        map[EmptyMode]any{EmptySkip: &plain{}, EmptyError: ErrEmptyValue, EmptyDefault: &plain{}},
+		},
+		{
+			name:        "int with default",
+			destination: func() any { return &defaulted{} },
+			want:        map[EmptyMode]any{EmptySkip: &defaulted{Age: 18}, EmptyError: ErrEmptyValue, EmptyDefault: &defaulted{Age: 18}},
+		},
+		{
+			name:        "required int",
+			destination: func() any { return &required{} },
+			want:        map[EmptyMode]any{EmptySkip: new(*RequiredError), EmptyError: new(*RequiredError), EmptyDefault: new(*RequiredError)},
+		},
+		{
+			name:        "pointer",
+			destination: func() any { return &pointer{} },
+			want:        map[EmptyMode]any{EmptySkip: &pointer{}, EmptyError: ErrEmptyValue, EmptyDefault: &pointer{}},
+		},
+		{
+			name:        "pointer with empty pointers",
+			destination: func() any { return &pointer{} },
+			pointers:    true,
+			want:        map[EmptyMode]any{EmptySkip: &pointer{Age: &zero}, EmptyError: ErrEmptyValue, EmptyDefault: &pointer{Age: &zero}},
+		},
+		{
+			name:        "pointer with default",
+			destination: func() any { return &defaultedPointer{} },
+			want:        map[EmptyMode]any{EmptySkip: &defaultedPointer{}, EmptyError: ErrEmptyValue, EmptyDefault: &defaultedPointer{Age: &eighteen}},
+		},
+		{
+			name:        "string",
+			destination: func() any { return &text{} },
+			want:        map[EmptyMode]any{EmptySkip: &text{}, EmptyError: &text{}, EmptyDefault: &text{}},
+		},
+	}
+
+	modes := map[EmptyMode]string{EmptySkip: "skip", EmptyError: "error", EmptyDefault: "default"}
+
+	for _, tt := range tests {
+		for mode, want := range tt.want {
+			t.Run(tt.name+"/"+modes[mode], func(t *testing.T) {
+				opts := []Option{WithEmptyValues(mode)}
+				if tt.pointers {
+					opts = append(opts, WithEmptyPointers())
+				}
+
+				got := tt.destination()
+				err := NewDecoder(opts...).Decode(httptest.NewRequest(http.MethodGet, "/?age=", nil), got)
+
+				switch want := want.(type) {
+				case error:
+					if !errors.Is(err, want) {
+						t.Errorf("Decode() error = %v, want %v", err, want)
+					}
+				case **RequiredError:
+					if !errors.As(err, want) {
+						t.Errorf("Decode() error = %v, want a *RequiredError", err)
+					}
+				default:
+					if err != nil {
+						t.Fatalf("Decode() error = %v", err)
+					}
+
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("Decode() = %+v, want %+v", got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWithExtendedBoolLiterals(t *testing.T) {
+	type settings struct {
+		Notify bool `query:"notify"`
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "on", opts: []Option{WithExtendedBoolLiterals()}, value: "on", want: true},
+		{name: "YES", opts: []Option{WithExtendedBoolLiterals()}, value: "YES", want: true},
+		{name: "y", opts: []Option{WithExtendedBoolLiterals()}, value: "y", want: true},
+		{name: "Off", opts: []Option{WithExtendedBoolLiterals()}, value: "Off", want: false},
+		{name: "no", opts: []Option{WithExtendedBoolLiterals()}, value: "no", want: false},
+		{name: "standard literal", opts: []Option{WithExtendedBoolLiterals()}, value: "true", want: true},
+		{name: "unknown literal", opts: []Option{WithExtendedBoolLiterals()}, value: "maybe", wantErr: true},
+		{name: "on without option", value: "on", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := settings{Notify: !tt.want}
+
+			err := NewDecoder(tt.opts...).Decode(httptest.NewRequest(http.MethodGet, "/?notify="+tt.value, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got.Notify != tt.want {
+				t.Errorf("Notify = %v, want %v", got.Notify, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithExtendedBoolLiteralsCheckbox(t *testing.T) {
+	type subscribe struct {
+		Newsletter bool `form:"newsletter"`
+		Terms      bool `form:"terms"`
+	}
+
+	// Browsers submit "on" for checked checkboxes and leave unchecked ones
+	// out.
+	request := newBodyRequest("/", "application/x-www-form-urlencoded", "newsletter=on")
+
+	var got subscribe
+	if err := NewDecoder(WithExtendedBoolLiterals()).Decode(request, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if want := (subscribe{Newsletter: true}); got != want {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWithMerge(t *testing.T) {
+	type filter struct {
+		Status string `query:"status"`
+	}
+
+	type list struct {
+		Size   int     `query:"size" default:"20"`
+		Sort   string  `query:"sort"`
+		Locale string  `header:"Accept-Language"`
+		Filter *filter `query:"filter"`
+	}
+
+	preset := func() list {
+		return list{Size: 50, Sort: "id", Locale: "tr", Filter: &filter{Status: "open"}}
+	}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		target string
+		want   list
+	}{
+		{
+			name:   "reset without merge",
+			target: "/",
+			want:   list{Size: 20},
+		},
+		{
+			name:   "preset values survive",
+			opts:   []Option{WithMerge()},
+			target: "/",
+			want:   preset(),
+		},
+		{
+			name:   "request values win",
+			opts:   []Option{WithMerge()},
+			target: "/?size=10&sort=name&filter.status=closed",
+			want:   list{Size: 10, Sort: "name", Locale: "tr", Filter: &filter{Status: "closed"}},
+		},
+		{
+			name:   "empty values keep presets",
+			opts:   []Option{WithMerge()},
+			target: "/?size=&sort=",
+			want:   preset(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preset()
+
+			if err := NewDecoder(tt.opts...).Decode(httptest.NewRequest(http.MethodGet, tt.target, nil), &got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// userIDKey is a context key type defined by middleware outside the package.
+type userIDKey struct{}
+
+// user is a value stored in the request context by authentication middleware.
+type user struct {
+	ID   int64
+	Name string
+}
+
+func TestWithContextKey(t *testing.T) {
+	type scoped struct {
+		User    *user  `context:"user"`
+		UserID  int64  `context:"user_id" required:"true"`
+		Tenant  string `context:"tenant"`
+		Missing string `context:"missing"`
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		values  map[any]any
+		want    scoped
+		wantErr bool
+	}{
+		{
+			name: "package keys",
+			values: map[any]any{
+				ContextKey("user"):    &user{ID: 7, Name: "ada"},
+				ContextKey("user_id"): int64(7),
+				ContextKey("tenant"):  "acme",
+			},
+			want: scoped{User: &user{ID: 7, Name: "ada"}, UserID: 7, Tenant: "acme"},
+		},
+		{
+			name:   "string converted",
+			values: map[any]any{ContextKey("user_id"): "42"},
+			want:   scoped{UserID: 42},
+		},
+		{
+			name:   "middleware key",
+			opts:   []Option{WithContextKey("user_id", userIDKey{})},
+			values: map[any]any{userIDKey{}: int64(9)},
+			want:   scoped{UserID: 9},
+		},
+		{
+			name:    "middleware key not configured",
+			values:  map[any]any{userIDKey{}: int64(9)},
+			wantErr: true,
+		},
+		{
+			name:    "unassignable value",
+			values:  map[any]any{ContextKey("user_id"): 3.5},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got scoped
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				err := NewDecoder(tt.opts...).Decode(r, &got)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+
+			// The upstream middleware stores its values before decoding.
+			middleware := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+
+				for key, value := range tt.values {
+					ctx = context.WithValue(ctx, key, value)
+				}
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+
+			middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTrimSpace(t *testing.T) {
+	type profile struct {
+		ID     int      `path:"id"`
+		Page   int      `query:"page"`
+		Tags   []string `query:"tags"`
+		Locale string   `header:"Accept-Language"`
+		Name   string   `form:"name" required:"true"`
+	}
+
+	newRequest := func(name string) *http.Request {
+		request := newBodyRequest("/?page=%2042%20&tags=%20a%20&tags=b%20", "application/x-www-form-urlencoded", "name="+url.QueryEscape(name))
+		request.SetPathValue("id", " 7 ")
+		request.Header.Set("Accept-Language", "\ten ")
+
+		return request
+	}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		request     *http.Request
+		want        profile
+		wantErr     bool
+		wantMissing bool
+	}{
+		{
+			name:    "trimmed across sources",
+			opts:    []Option{WithTrimSpace()},
+			request: newRequest(" ada "),
+			want:    profile{ID: 7, Page: 42, Tags: []string{"a", "b"}, Locale: "en", Name: "ada"},
+		},
+		{
+			name:        "blank value counts as empty",
+			opts:        []Option{WithTrimSpace()},
+			request:     newRequest("   "),
+			wantErr:     true,
+			wantMissing: true,
+		},
+		{
+			name:    "untrimmed by default",
+			request: newRequest("ada"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got profile
+
+			err := NewDecoder(tt.opts...).Decode(tt.request, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var requiredErr *RequiredError
+			if tt.wantMissing != errors.As(err, &requiredErr) {
+				t.Errorf("Decode() error = %v, wantMissing %v", err, tt.wantMissing)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithBodyMethods(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+		Page int    `query:"page"`
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		opts   []Option
+		want   payload
+	}{
+		{name: "get body ignored", method: http.MethodGet, opts: []Option{WithBodyMethods("post", "PUT")}, want: payload{Page: 2}},
+		{name: "post body decoded", method: http.MethodPost, opts: []Option{WithBodyMethods("post", "PUT")}, want: payload{Name: "ada", Page: 2}},
+		{name: "put body decoded", method: http.MethodPut, opts: []Option{WithBodyMethods("post", "PUT")}, want: payload{Name: "ada", Page: 2}},
+		{name: "every method by default", method: http.MethodGet, want: payload{Name: "ada", Page: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/?page=2", strings.NewReader(`{"name":"ada"}`))
+			request.Header.Set("Content-Type", "application/json")
+
+			var got payload
+			if err := NewDecoder(tt.opts...).Decode(request, &got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithBodyMethodsSlice(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(`[{"name":"ada"}]`))
+	request.Header.Set("Content-Type", "application/json")
+
+	var got []item
+	if err := NewDecoder(WithBodyMethods(http.MethodPost)).Decode(request, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if got != nil {
+		t.Errorf("Decode() = %+v, want nil", got)
+	}
+}
+
+func TestWithValidator(t *testing.T) {
+	type signup struct {
+		Email string `form:"email"`
+	}
+
+	errMissingEmail := errors.New("email is required")
+
+	// The stub stands in for a tag-driven validator, checking what was mapped.
+	var validated []any
+
+	validator := func(v any) error {
+		validated = append(validated, v)
+
+		if v.(*signup).Email == "" {
+			return errMissingEmail
+		}
+
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{name: "accepted", body: "email=ada%40example.com"},
+		{name: "rejected", body: "email=", wantErr: errMissingEmail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validated = nil
+
+			var got signup
+
+			// The error of the validator is returned verbatim, not wrapped.
+			err := NewDecoder(WithValidator(validator)).Decode(newBodyRequest("/", "application/x-www-form-urlencoded", tt.body), &got)
+			if err != tt.wantErr {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(validated) != 1 || validated[0] != any(&got) {
+				t.Errorf("validator called with %v, want the destination once", validated)
+			}
+		})
+	}
+}
+
+func TestWithStrictTags(t *testing.T) {
+	type conflicting struct {
+		Name string `query:"name"`
+		ID   string `query:"id" header:"X-Id"`
+	}
+
+	type single struct {
+		Name string `query:"name"`
+		ID   string `header:"X-Id"`
+	}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		destination any
+		want        *TagConflictError
+	}{
+		{name: "conflict rejected", opts: []Option{WithStrictTags()}, destination: &conflicting{}, want: &TagConflictError{Field: "ID", Sources: []string{"header", "query"}}},
+		{name: "single tags accepted", opts: []Option{WithStrictTags()}, destination: &single{}},
+		{name: "conflict tolerated by default", destination: &conflicting{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/?name=ada&id=1", nil)
+			request.Header.Set("X-Id", "2")
+
+			err := NewDecoder(tt.opts...).Decode(request, tt.destination)
+
+			var conflictErr *TagConflictError
+			if (tt.want != nil) != errors.As(err, &conflictErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.want)
+			}
+
+			if tt.want == nil && err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if tt.want != nil && !reflect.DeepEqual(conflictErr, tt.want) {
+				t.Errorf("Decode() error = %+v, want %+v", conflictErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagPrecedence(t *testing.T) {
+	// A field carrying every source tag lists them in the documented order.
+	field := reflect.StructField{
+		Name: "Value",
+		Tag:  `source:"query:v" body:"" context:"v" auth:"bearer" meta:"method" trailer:"X-V" cookie:"v" path:"v" query:"v" header:"X-V" file:"v" form:"v"`,
+	}
+
+	want := []string{"form", "file", "header", "query", "path", "cookie", "trailer", "meta", "auth", "context", "body", "source"}
+	if got := fieldSources(field, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("fieldSources() = %v, want %v", got, want)
+	}
+
+	type pairs struct {
+		FormOverHeader    string `header:"X-A" form:"a"`
+		HeaderOverQuery   string `query:"b" header:"X-B"`
+		QueryOverPath     string `path:"c" query:"c"`
+		PathOverCookie    string `cookie:"d" path:"d"`
+		CookieOverTrailer string `trailer:"X-E" cookie:"e"`
+		MetaOverAuth      string `auth:"bearer" meta:"method"`
+	}
+
+	request := newBodyRequest("/?c=query", "application/x-www-form-urlencoded", "a=form")
+	request.Header.Set("X-A", "header")
+	request.Header.Set("X-B", "header")
+	request.Header.Set("Authorization", "Bearer auth")
+	request.SetPathValue("c", "path")
+	request.SetPathValue("d", "path")
+	request.AddCookie(&http.Cookie{Name: "d", Value: "cookie"})
+	request.AddCookie(&http.Cookie{Name: "e", Value: "cookie"})
+
+	var got pairs
+	if err := Convert(request, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	wantPairs := pairs{
+		FormOverHeader:    "form",
+		HeaderOverQuery:   "header",
+		QueryOverPath:     "query",
+		PathOverCookie:    "path",
+		CookieOverTrailer: "cookie",
+		MetaOverAuth:      http.MethodPost,
+	}
+
+	if got != wantPairs {
+		t.Errorf("Convert() = %+v, want %+v", got, wantPairs)
+	}
+}
+
+func TestWithCollectErrors(t *testing.T) {
+	type address struct {
+		Zip  int    `query:"zip"`
+		City string `query:"city" required:"true"`
+	}
+
+	type order struct {
+		Name    string  `json:"name"`
+		Page    int     `query:"page"`
+		Token   string  `header:"X-Token" required:"true"`
+		Address address `query:"address"`
+	}
+
+	request := newJSONRequest("/?page=x&address.zip=y", `{"name":`)
+
+	var got order
+
+	err := NewDecoder(WithCollectErrors()).Decode(request, &got)
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Decode() error = %v, want Errors", err)
+	}
+
+	// The errors of the nested struct are flattened into the list.
+	want := []struct {
+		field  string
+		source string
+	}{
+		{field: "", source: "body"},
+		{field: "Page", source: "query"},
+		{field: "Token", source: "header"},
+		{field: "Zip", source: "query"},
+		{field: "City", source: "query"},
+	}
+
+	if len(errs) != len(want) {
+		t.Fatalf("Decode() returned %d errors, want %d: %v", len(errs), len(want), err)
+	}
+
+	for i, err := range errs {
+		var (
+			field, source string
+			convertErr    *ConvertError
+			requiredErr   *RequiredError
+		)
+
+		switch {
+		case errors.As(err, &convertErr):
+			field, source = convertErr.Field, convertErr.Source
+		case errors.As(err, &requiredErr):
+			field, source = requiredErr.Field, requiredErr.Source
+		}
+
+		if field != want[i].field || source != want[i].source {
+			t.Errorf("errs[%d] = %v, want %s field from %s", i, err, want[i].field, want[i].source)
+		}
+	}
+}
+
+func TestWithCollectErrorsDisabled(t *testing.T) {
+	type order struct {
+		Page  int    `query:"page"`
+		Token string `header:"X-Token" required:"true"`
+	}
+
+	var got order
+
+	err := NewDecoder().Decode(httptest.NewRequest(http.MethodGet, "/?page=x", nil), &got)
+
+	var errs Errors
+	if errors.As(err, &errs) {
+		t.Fatalf("Decode() error = %v, want the first error only", err)
+	}
+
+	var convertErr *ConvertError
+	if !errors.As(err, &convertErr) || convertErr.Field != "Page" {
+		t.Errorf("Decode() error = %v, want a *ConvertError for Page", err)
+	}
+}
+
+func TestWithTagName(t *testing.T) {
+	type echoStyle struct {
+		ID     int    `param:"id"`
+		Page   int    `url:"page"`
+		Token  string `hdr:"X-Token"`
+		Ignore int    `query:"page"`
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/?page=2", nil)
+	request.SetPathValue("id", "42")
+	request.Header.Set("X-Token", "secret")
+
+	decoder := NewDecoder(WithTagName("path", "param"), WithTagName("query", "url"), WithTagName("header", "hdr"))
+
+	var got echoStyle
+	if err := decoder.Decode(request, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	// The renamed sources no longer read their default tags.
+	want := echoStyle{ID: 42, Page: 2, Token: "secret"}
+	if got != want {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWithCaseInsensitiveKeys(t *testing.T) {
+	type filter struct {
+		Status string `query:"status"`
+	}
+
+	type search struct {
+		Page   int               `query:"page"`
+		Sort   string            `form:"sortBy"`
+		Filter filter            `query:"filter"`
+		Extra  map[string]string `query:"extra"`
+	}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		target string
+		body   string
+		want   search
+	}{
+		{
+			name:   "mixed case keys",
+			opts:   []Option{WithCaseInsensitiveKeys()},
+			target: "/?PAGE=2&Filter.Status=open&EXTRA[Color]=red",
+			body:   "SORTBY=name",
+			want:   search{Page: 2, Sort: "name", Filter: filter{Status: "open"}, Extra: map[string]string{"color": "red"}},
+		},
+		{
+			name:   "first key sorting byte-wise wins",
+			opts:   []Option{WithCaseInsensitiveKeys()},
+			target: "/?page=3&Page=2",
+			want:   search{Page: 2},
+		},
+		{
+			name:   "case sensitive by default",
+			target: "/?PAGE=2&page=3",
+			body:   "SORTBY=name",
+			want:   search{Page: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got search
+
+			request := newBodyRequest(tt.target, "application/x-www-form-urlencoded", tt.body)
+			if err := NewDecoder(tt.opts...).Decode(request, &got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package http2struct
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Encode populates request from the fields of source, a struct or pointer to
+// struct, reversing Convert: query fields are added to the URL, header and
+// cookie fields to the headers, path fields to the path values, and form
+// fields to an application/x-www-form-urlencoded body. When any field has a
+// json tag and no form field is set, the fields without a source tag are
+// marshaled into a JSON body instead. File and raw body fields are not
+// encoded, and nil pointers are omitted.
+//
+// Values are formatted so that Convert maps them back into an equal struct.
+func Encode(request *http.Request, source any) error {
+	return defaultDecoder.Encode(request, source)
+}
+
+// Encode populates request from the fields of source using the tag names of
+// d. See the package-level Encode for details.
+func (d *Decoder) Encode(request *http.Request, source any) error {
+	if request == nil {
+		return fmt.Errorf("request cannot be nil")
+	}
+
+	v, err := sourceValue(source)
+	if err != nil {
+		return err
+	}
+
+	state := &encodeState{
+		request: request,
+		query:   request.URL.Query(),
+		form:    url.Values{},
+	}
+
+	if err := d.encodeFields(state, v, d.plan(v.Type()), ""); err != nil {
+		return err
+	}
+
+	request.URL.RawQuery = state.query.Encode()
+
+	hasJSON := hasJSONFields(v.Type())
+
+	if len(state.form) > 0 && hasJSON {
+		return fmt.Errorf("cannot encode both form and json fields into the request body")
+	}
+
+	switch {
+	case len(state.form) > 0:
+		setBody(request, "application/x-www-form-urlencoded", []byte(state.form.Encode()))
+	case hasJSON:
+		body, err := marshalBody(v, d.plan(v.Type()))
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+
+		setBody(request, "application/json", body)
+	}
+
+	return nil
+}
+
+// Values returns the url.Values encoded from the query fields of v, a struct
+// or pointer to struct, reversing ConvertValues. Slices add one value per
+// element under the same key, and nil pointers and fields tagged `query:"-"`
+// are omitted.
+func Values(v any) (url.Values, error) {
+	source, err := sourceValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &encodeState{
+		query:     url.Values{},
+		queryOnly: true,
+	}
+
+	if err := defaultDecoder.encodeFields(state, source, defaultDecoder.plan(source.Type()), ""); err != nil {
+		return nil, err
+	}
+
+	return state.query, nil
+}
+
+// encodeState holds the values collected from the fields of an Encode call.
+type encodeState struct {
+	request *http.Request
+	query   url.Values
+	form    url.Values
+
+	queryOnly bool // Whether only query fields are encoded, as by Values
+}
+
+// sourceValue returns the struct that source is, or points to.
+func sourceValue(source any) (reflect.Value, error) {
+	v := reflect.ValueOf(source)
+
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("source cannot be nil")
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}, fmt.Errorf("source cannot be nil")
+		}
+
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, ErrNotStruct
+	}
+
+	return v, nil
+}
+
+// encodeFields adds the fields of the struct v described by plan to state.
+// Query and form names are prefixed with prefix, like in decodeFields.
+func (d *Decoder) encodeFields(state *encodeState, v reflect.Value, plan *typePlan, prefix string) error {
+	for _, f := range plan.fields {
+		fieldValue := v.Field(f.index)
+
+		if state.queryOnly && f.embed == nil && f.source != sourceQuery {
+			continue
+		}
+
+		if f.embed != nil || f.nested {
+			if fieldValue.Kind() == reflect.Pointer {
+				if fieldValue.IsNil() {
+					continue
+				}
+
+				fieldValue = fieldValue.Elem()
+			}
+
+			nestedPrefix := prefix
+			if f.nested {
+				nestedPrefix = prefix + f.name + "."
+			}
+
+			if err := d.encodeFields(state, fieldValue, d.plan(fieldValue.Type()), nestedPrefix); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		switch f.source {
+		case sourceQuery, sourceForm:
+			values := state.query
+			if f.source == sourceForm {
+				values = state.form
+			}
+
+			if err := d.encodeValues(values, fieldValue, f.field.Tag, prefix, f.name); err != nil {
+				return fmt.Errorf("failed to encode %q field to %q %s: %w", f.field.Name, prefix+f.name, f.source, err)
+			}
+		case sourceHeader, sourceCookie, sourcePath:
+			if f.source == sourceHeader && f.name == "*" && fieldValue.Kind() == reflect.Map {
+				header := url.Values{}
+
+				if err := d.encodeValues(header, fieldValue, f.field.Tag, "", "*"); err != nil {
+					return fmt.Errorf("failed to encode %q field to headers: %w", f.field.Name, err)
+				}
+
+				for key, values := range header {
+					for _, value := range values {
+						state.request.Header.Add(key, value)
+					}
+				}
+
+				continue
+			}
+
+			values, err := d.formatValue(fieldValue, f.field.Tag)
+			if err != nil {
+				return fmt.Errorf("failed to encode %q field to %q %s: %w", f.field.Name, f.name, f.source, err)
+			}
+
+			// A path value holds every element of a slice at once, joined
+			// the way catch-all wildcards are split.
+			if f.source == sourcePath && len(values) > 0 && !d.isScalar(fieldValue.Type()) {
+				delim, ok := f.field.Tag.Lookup("delim")
+				if !ok {
+					delim = "/"
+				}
+
+				values = []string{strings.Join(values, delim)}
+			}
+
+			for _, value := range values {
+				switch f.source {
+				case sourceHeader:
+					state.request.Header.Add(f.name, value)
+				case sourceCookie:
+					state.request.AddCookie(&http.Cookie{Name: f.name, Value: value})
+				case sourcePath:
+					state.request.SetPathValue(f.name, value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// encodeValues adds the formatted value of field to values under prefix+name.
+// Maps add one key per entry, reversing convertMap.
+func (d *Decoder) encodeValues(values url.Values, field reflect.Value, tag reflect.StructTag, prefix, name string) error {
+	if field.Kind() != reflect.Map {
+		vs, err := d.formatValue(field, tag)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range vs {
+			values.Add(prefix+name, value)
+		}
+
+		return nil
+	}
+
+	if field.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("%w %q for map key", ErrUnsupportedKind, field.Type().Key().Kind().String())
+	}
+
+	iter := field.MapRange()
+
+	for iter.Next() {
+		vs, err := d.formatValue(iter.Value(), tag)
+		if err != nil {
+			return fmt.Errorf("failed to format map value for %q key: %w", iter.Key().String(), err)
+		}
+
+		key := prefix + iter.Key().String()
+		if name != "*" {
+			key = prefix + name + "[" + iter.Key().String() + "]"
+		}
+
+		for _, value := range vs {
+			values.Add(key, value)
+		}
+	}
+
+	return nil
+}
+
+// formatValue formats v as request values, reversing convert. Nil pointers
+// yield no value, and slices and arrays yield one value per element. Elements
+// that are slices or arrays themselves are joined on the `delim` separator.
+func (d *Decoder) formatValue(v reflect.Value, tag reflect.StructTag) ([]string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		v = v.Elem()
+	}
+
+	if !d.isScalar(v.Type()) {
+		values := make([]string, 0, v.Len())
+
+		for i := range v.Len() {
+			value, err := d.formatElement(v.Index(i), tag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to format element for index %d: %w", i, err)
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+	}
+
+	value, err := formatScalar(v, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{value}, nil
+}
+
+// formatElement formats a slice or array element as a single value.
+func (d *Decoder) formatElement(v reflect.Value, tag reflect.StructTag) (string, error) {
+	if d.isScalar(v.Type()) {
+		return formatScalar(v, tag)
+	}
+
+	values, err := d.formatValue(v, tag)
+	if err != nil {
+		return "", err
+	}
+
+	delim := tag.Get("delim")
+	if delim == "" {
+		delim = ","
+	}
+
+	return strings.Join(values, delim), nil
+}
+
+// formatScalar formats a single value the way convert parses it.
+func formatScalar(v reflect.Value, tag reflect.StructTag) (string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "", nil
+		}
+
+		v = v.Elem()
+	}
+
+	switch baseType(v.Type()) {
+	case timeType:
+		t := v.Convert(timeType).Interface().(time.Time)
+
+		switch layout := tag.Get("timeformat"); layout {
+		case "":
+			return t.Format(time.RFC3339Nano), nil
+		case "unix":
+			return strconv.FormatInt(t.Unix(), 10), nil
+		case "unixmilli":
+			return strconv.FormatInt(t.UnixMilli(), 10), nil
+		default:
+			return t.Format(layout), nil
+		}
+	case durationType:
+		return time.Duration(v.Int()).String(), nil
+	case urlType:
+		u := v.Convert(urlType).Interface().(url.URL)
+
+		return u.String(), nil
+	}
+
+	if v.Type().Implements(textMarshalerType) || reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+		if !v.CanAddr() {
+			copied := reflect.New(v.Type()).Elem()
+			copied.Set(v)
+			v = copied
+		}
+
+		text, err := v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %q to text: %w", v.Type().String(), err)
+		}
+
+		return string(text), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w %q", ErrUnsupportedKind, v.Kind().String())
+}
+
+// hasJSONFields reports whether any field of t, including promoted ones, has
+// a json tag.
+func hasJSONFields(t reflect.Type) bool {
+	for _, field := range reflect.VisibleFields(t) {
+		if tag, ok := field.Tag.Lookup("json"); ok && tag != "-" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// marshalBody marshals the fields of v that have no source tag into a JSON
+// object, leaving out the header, query, file and other fields of plan, which
+// are sent elsewhere or not at all.
+func marshalBody(v reflect.Value, plan *typePlan) ([]byte, error) {
+	body, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]json.RawMessage
+
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+
+	for _, name := range sourceKeys(plan) {
+		delete(object, name)
+	}
+
+	return json.Marshal(object)
+}
+
+// sourceKeys returns the JSON keys of the fields of plan populated from a
+// source other than the body, including those promoted from embedded structs.
+func sourceKeys(plan *typePlan) []string {
+	var keys []string
+
+	for _, f := range plan.fields {
+		if f.embed != nil {
+			keys = append(keys, sourceKeys(f.embed)...)
+
+			continue
+		}
+
+		if f.unmarshal {
+			continue
+		}
+
+		key, _, _ := strings.Cut(f.field.Tag.Get("json"), ",")
+		if key == "" {
+			key = f.field.Name
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// setBody replaces the body of request with body of the given content type.
+func setBody(request *http.Request, contentType string, body []byte) {
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	request.Header.Set("Content-Type", contentType)
+}
@@ -0,0 +1,191 @@
+package http2struct
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	type profile struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Page  int      `query:"page"`
+		Sort  []string `query:"sort"`
+		Token string   `header:"Authorization"`
+		Limit *int     `query:"limit"`
+	}
+
+	type search struct {
+		Query  string    `form:"q"`
+		Active bool      `form:"active"`
+		Since  time.Time `query:"since"`
+		Locale string    `header:"Accept-Language"`
+	}
+
+	limit := 10
+
+	tests := []struct {
+		name   string
+		source any
+	}{
+		{
+			name:   "json body with query and header",
+			source: &profile{Name: "ada", Tags: []string{"a", "b"}, Page: 2, Sort: []string{"name", "id"}, Token: "Bearer x", Limit: &limit},
+		},
+		{
+			name:   "nil pointer omitted",
+			source: &profile{Name: "ada", Page: 1},
+		},
+		{
+			name:   "form body",
+			source: &search{Query: "go", Active: true, Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Locale: "en"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+
+			if err := Encode(request, tt.source); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			got := reflect.New(reflect.TypeOf(tt.source).Elem())
+			if err := Convert(request, got.Interface()); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Interface(), tt.source) {
+				t.Errorf("Convert(Encode()) = %+v, want %+v", got.Interface(), tt.source)
+			}
+		})
+	}
+}
+
+func TestEncodeBodyExcludesSourceFields(t *testing.T) {
+	type login struct {
+		User  string `json:"user"`
+		Token string `header:"Authorization" json:"token"`
+		Page  int    `query:"page"`
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	if err := Encode(request, login{User: "ada", Token: "secret", Page: 2}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+
+	want := map[string]any{"user": "ada"}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body = %v, want %v", body, want)
+	}
+}
+
+func TestEncodePathSegments(t *testing.T) {
+	type file struct {
+		Segments []string `path:"path"`
+		Parts    []string `path:"parts" delim:","`
+	}
+
+	tests := []struct {
+		name   string
+		source file
+		want   map[string]string
+	}{
+		{
+			name:   "segments",
+			source: file{Segments: []string{"a", "b", "c"}},
+			want:   map[string]string{"path": "a/b/c", "parts": ""},
+		},
+		{
+			name:   "delim tag",
+			source: file{Segments: []string{"a"}, Parts: []string{"x", "y"}},
+			want:   map[string]string{"path": "a", "parts": "x,y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if err := Encode(request, tt.source); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			for name, want := range tt.want {
+				if got := request.PathValue(name); got != want {
+					t.Errorf("PathValue(%q) = %q, want %q", name, got, want)
+				}
+			}
+
+			var got file
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.source) {
+				t.Errorf("Convert(Encode()) = %q, want %q", got, tt.source)
+			}
+		})
+	}
+}
+
+func TestValues(t *testing.T) {
+	type search struct {
+		Query  string   `query:"q"`
+		Tags   []string `query:"tag"`
+		IDs    []int    `query:"id"`
+		Limit  *int     `query:"limit"`
+		Secret string   `query:"-"`
+		Token  string   `header:"Authorization"`
+	}
+
+	tests := []struct {
+		name   string
+		source any
+		want   url.Values
+	}{
+		{
+			name:   "slices as repeated keys",
+			source: search{Query: "go", Tags: []string{"a", "b"}, IDs: []int{1, 2, 3}},
+			want:   url.Values{"q": {"go"}, "tag": {"a", "b"}, "id": {"1", "2", "3"}},
+		},
+		{
+			name:   "pointer source",
+			source: &search{Tags: []string{"a"}},
+			want:   url.Values{"q": {""}, "tag": {"a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Values(tt.source)
+			if err != nil {
+				t.Fatalf("Values() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values() = %v, want %v", got, tt.want)
+			}
+
+			var decoded search
+			if err := ConvertValues(got, &decoded); err != nil {
+				t.Fatalf("ConvertValues() error = %v", err)
+			}
+
+			if want := reflect.Indirect(reflect.ValueOf(tt.source)).Interface(); !reflect.DeepEqual(decoded, want) {
+				t.Errorf("ConvertValues(Values()) = %+v, want %+v", decoded, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package http2struct
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FileTypeError is returned when an uploaded file's content type is not listed
+// in the field's `accept` tag.
+type FileTypeError struct {
+	Field       string // Name of the struct field
+	Name        string // Name of the file within its source
+	ContentType string // Rejected content type
+}
+
+func (e *FileTypeError) Error() string {
+	return fmt.Sprintf("file %q has unaccepted content type %q for %q field", e.Name, e.ContentType, e.Field)
+}
+
+// FileSizeError is returned when an uploaded file is larger than the size
+// given in the field's `maxsize` tag.
+type FileSizeError struct {
+	Field   string // Name of the struct field
+	Name    string // Name of the file within its source
+	MaxSize int64  // Maximum accepted size in bytes
+}
+
+func (e *FileSizeError) Error() string {
+	return fmt.Sprintf("file %q exceeds maximum size of %d bytes for %q field", e.Name, e.MaxSize, e.Field)
+}
+
+// formFiles returns the files uploaded under name, in submission order,
+// followed by those uploaded under indexed keys such as name[0] and name[1],
+// in index order.
+func formFiles(form *multipart.Form, name string) []*multipart.FileHeader {
+	files := slices.Clone(form.File[name])
+
+	type indexed struct {
+		index int
+		files []*multipart.FileHeader
+	}
+
+	var keys []indexed
+
+	for key, fileHeaders := range form.File {
+		i, ok := strings.CutPrefix(key, name+"[")
+		if !ok {
+			continue
+		}
+
+		i, ok = strings.CutSuffix(i, "]")
+		if !ok {
+			continue
+		}
+
+		index, err := strconv.Atoi(i)
+		if err != nil || index < 0 {
+			continue
+		}
+
+		keys = append(keys, indexed{index: index, files: fileHeaders})
+	}
+
+	slices.SortFunc(keys, func(a, b indexed) int {
+		return a.index - b.index
+	})
+
+	for _, key := range keys {
+		files = append(files, key.files...)
+	}
+
+	return files
+}
+
+// readFile reads an uploaded file into memory, closing it before returning so
+// that a struct with many file fields never holds more than one file open.
+func readFile(fileHeader *multipart.FileHeader) (File, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return File{}, fmt.Errorf("failed to open %q file: %w", fileHeader.Filename, err)
+	}
+
+	content, err := io.ReadAll(file)
+
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return File{}, fmt.Errorf("failed to read %q file: %w", fileHeader.Filename, err)
+	}
+
+	return File{
+		Name:        fileHeader.Filename,
+		Size:        fileHeader.Size,
+		ContentType: fileHeader.Header.Get("Content-Type"),
+		Content:     content,
+	}, nil
+}
+
+// openFile opens an uploaded file for streaming, checking its content type
+// against the field's `accept` tag without reading it into memory. The
+// returned stream is left open for the caller to close.
+func (d *Decoder) openFile(field reflect.StructField, name string, fileHeader *multipart.FileHeader) (FileStream, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return FileStream{}, &ConvertError{Field: field.Name, Tag: name, Source: "file", Err: fmt.Errorf("failed to open %q file: %w", fileHeader.Filename, err)}
+	}
+
+	s := FileStream{
+		Name:        fileHeader.Filename,
+		Size:        fileHeader.Size,
+		ContentType: fileHeader.Header.Get("Content-Type"),
+		Reader:      file,
+	}
+
+	if accept, ok := field.Tag.Lookup("accept"); !ok || accept == "" {
+		return s, nil
+	}
+
+	// Only the first 512 bytes are considered by http.DetectContentType, and
+	// the file is rewound afterwards so the caller still reads all of it.
+	head := make([]byte, 512)
+
+	n, err := io.ReadFull(file, head)
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		_ = file.Close()
+
+		return FileStream{}, &ConvertError{Field: field.Name, Tag: name, Source: "file", Err: fmt.Errorf("failed to read %q file: %w", fileHeader.Filename, err)}
+	}
+
+	if err := d.checkFileType(field, name, File{ContentType: s.ContentType, Content: head[:n]}); err != nil {
+		_ = file.Close()
+
+		return FileStream{}, err
+	}
+
+	return s, nil
+}
+
+// loadFile returns an uploaded file checked against the field's `accept` tag,
+// with its content read into memory unless meta is true.
+func (d *Decoder) loadFile(field reflect.StructField, name string, fileHeader *multipart.FileHeader, meta bool) (File, error) {
+	if meta {
+		return d.fileMeta(field, name, fileHeader)
+	}
+
+	f, err := readFile(fileHeader)
+	if err != nil {
+		return File{}, &ConvertError{Field: field.Name, Tag: name, Source: "file", Err: err}
+	}
+
+	if err := d.checkFileType(field, name, f); err != nil {
+		return File{}, err
+	}
+
+	return f, nil
+}
+
+// fileMeta returns the metadata of an uploaded file without its content. The
+// file is only opened when the field has an `accept` tag, to check its content
+// type against the first 512 bytes.
+func (d *Decoder) fileMeta(field reflect.StructField, name string, fileHeader *multipart.FileHeader) (File, error) {
+	f := File{
+		Name:        fileHeader.Filename,
+		Size:        fileHeader.Size,
+		ContentType: fileHeader.Header.Get("Content-Type"),
+	}
+
+	if accept, ok := field.Tag.Lookup("accept"); !ok || accept == "" {
+		return f, nil
+	}
+
+	s, err := d.openFile(field, name, fileHeader)
+	if err != nil {
+		return File{}, err
+	}
+
+	_ = s.Reader.Close()
+
+	return f, nil
+}
+
+// closeStreams closes the streams already opened into files, a slice of
+// FileStream, *FileStream or io.ReadCloser, when a later file fails.
+func closeStreams(files reflect.Value) {
+	for i := range files.Len() {
+		switch s := files.Index(i).Interface().(type) {
+		case FileStream:
+			_ = s.Reader.Close()
+		case *FileStream:
+			_ = s.Reader.Close()
+		case io.ReadCloser:
+			_ = s.Close()
+		}
+	}
+}
+
+// checkFileType verifies that f has a content type listed in the field's
+// `accept` tag. The declared content type is checked, falling back to the type
+// detected from the content when the client did not declare one. With content
+// sniffing enabled the detected type must be accepted as well, so a client
+// cannot smuggle a file past the check by declaring a false type.
+func (d *Decoder) checkFileType(field reflect.StructField, name string, f File) error {
+	accept, ok := field.Tag.Lookup("accept")
+	if !ok || accept == "" {
+		return nil
+	}
+
+	detected := mediaType(http.DetectContentType(f.Content))
+
+	declared := mediaType(f.ContentType)
+	if declared == "" {
+		declared = detected
+	}
+
+	if !acceptsType(accept, declared) {
+		return &FileTypeError{Field: field.Name, Name: name, ContentType: declared}
+	}
+
+	if d.sniffContentType && !acceptsType(accept, detected) {
+		return &FileTypeError{Field: field.Name, Name: name, ContentType: detected}
+	}
+
+	return nil
+}
+
+// acceptsType reports whether contentType matches one of the comma-separated
+// media types in accept. Entries such as "image/*" match a whole type.
+func acceptsType(accept, contentType string) bool {
+	for allowed := range strings.SplitSeq(accept, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+
+		if allowed == contentType {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mediaType returns the lowercase media type of a Content-Type value without
+// its parameters.
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+
+	base, _, _ := strings.Cut(contentType, ";")
+
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+// fileMaxSize returns the size in bytes given in the field's `maxsize` tag, or
+// zero when the field has no such tag.
+func fileMaxSize(field reflect.StructField) (int64, error) {
+	tag, ok := field.Tag.Lookup("maxsize")
+	if !ok || tag == "" {
+		return 0, nil
+	}
+
+	return parseSize(tag)
+}
+
+// sizeUnits maps the suffixes accepted by parseSize to their multipliers.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as "512", "64KB", "5MB" or "1GB". Units
+// are binary multiples, so "1KB" is 1024 bytes, and are case-insensitive.
+func parseSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+
+	for _, unit := range sizeUnits {
+		if n, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, multiplier = strings.TrimSpace(n), unit.multiplier
+
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+
+	return size * multiplier, nil
+}
+
+// dispositionFilename returns the filename carried by a Content-Disposition
+// header, or an empty string when there is none. An RFC 5987 "filename*"
+// parameter such as UTF-8'en'my%20file.txt is preferred over a plain "filename"
+// and returned decoded, without its charset and language prefix.
+func dispositionFilename(contentDisposition string) string {
+	_, params, err := mime.ParseMediaType(contentDisposition)
+	if err != nil {
+		return ""
+	}
+
+	// mime.ParseMediaType decodes UTF-8 and US-ASCII "filename*" values into
+	// params["filename"] but drops ISO-8859-1 ones, which RFC 5987 also
+	// requires recipients to support.
+	for param := range strings.SplitSeq(contentDisposition, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "filename*") {
+			continue
+		}
+
+		charset, rest, _ := strings.Cut(strings.TrimSpace(value), "'")
+		_, encoded, _ := strings.Cut(rest, "'")
+
+		if !strings.EqualFold(charset, "ISO-8859-1") {
+			break
+		}
+
+		decoded, err := url.PathUnescape(encoded)
+		if err != nil {
+			break
+		}
+
+		runes := make([]rune, len(decoded))
+		for i := range len(decoded) {
+			runes[i] = rune(decoded[i])
+		}
+
+		return string(runes)
+	}
+
+	return params["filename"]
+}
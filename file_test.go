@@ -0,0 +1,719 @@
+package http2struct
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fileNames returns the name and content of each file.
+func fileNames(files []File) []string {
+	names := make([]string, len(files))
+
+	for i, file := range files {
+		names[i] = file.Name + ":" + string(file.Content)
+	}
+
+	return names
+}
+
+func TestConvertMultipleFiles(t *testing.T) {
+	type upload struct {
+		Attachments []File  `file:"attachments"`
+		Documents   []*File `file:"documents"`
+		Avatar      File    `file:"avatar"`
+	}
+
+	tests := []struct {
+		name      string
+		parts     []formPart
+		want      []string
+		documents int
+		avatar    string
+	}{
+		{
+			name: "two files",
+			parts: []formPart{
+				{name: "attachments", filename: "a.txt", content: "a"},
+				{name: "attachments", filename: "b.txt", content: "b"},
+			},
+			want: []string{"a.txt:a", "b.txt:b"},
+		},
+		{
+			name: "three files",
+			parts: []formPart{
+				{name: "attachments", filename: "a.txt", content: "a"},
+				{name: "attachments", filename: "b.txt", content: "b"},
+				{name: "attachments", filename: "c.txt", content: "c"},
+			},
+			want: []string{"a.txt:a", "b.txt:b", "c.txt:c"},
+		},
+		{
+			name: "pointer slice",
+			parts: []formPart{
+				{name: "documents", filename: "a.pdf", content: "a"},
+				{name: "documents", filename: "b.pdf", content: "b"},
+			},
+			want:      []string{},
+			documents: 2,
+		},
+		{
+			name: "single file alongside",
+			parts: []formPart{
+				{name: "attachments", filename: "a.txt", content: "a"},
+				{name: "avatar", filename: "me.png", content: "png"},
+				{name: "avatar", filename: "other.png", content: "other"},
+			},
+			want:   []string{"a.txt:a"},
+			avatar: "me.png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got upload
+			if err := Convert(newMultipartRequest(t, tt.parts), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if names := fileNames(got.Attachments); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Attachments = %v, want %v", names, tt.want)
+			}
+
+			if len(got.Documents) != tt.documents {
+				t.Errorf("len(Documents) = %d, want %d", len(got.Documents), tt.documents)
+			}
+
+			if got.Avatar.Name != tt.avatar {
+				t.Errorf("Avatar.Name = %q, want %q", got.Avatar.Name, tt.avatar)
+			}
+		})
+	}
+}
+
+func TestConvertBinaryFilename(t *testing.T) {
+	type upload struct {
+		File File `file:"binary"`
+	}
+
+	tests := []struct {
+		name        string
+		disposition string
+		want        string
+	}{
+		{
+			name:        "plain filename",
+			disposition: `attachment; filename="report.txt"`,
+			want:        "report.txt",
+		},
+		{
+			name:        "UTF-8 with spaces",
+			disposition: `attachment; filename*=UTF-8''my%20file.txt`,
+			want:        "my file.txt",
+		},
+		{
+			name:        "UTF-8 non-ASCII with language",
+			disposition: `attachment; filename*=UTF-8'tr'%C3%B6zet%20rapor.txt`,
+			want:        "özet rapor.txt",
+		},
+		{
+			name:        "ISO-8859-1",
+			disposition: `attachment; filename*=ISO-8859-1''%E9t%E9.txt`,
+			want:        "été.txt",
+		},
+		{
+			name:        "filename* preferred",
+			disposition: `attachment; filename="fallback.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`,
+			want:        "€ rates.txt",
+		},
+		{
+			name:        "no disposition",
+			disposition: "",
+			want:        "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("content"))
+
+			if tt.disposition != "" {
+				request.Header.Set("Content-Disposition", tt.disposition)
+			}
+
+			var got upload
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got.File.Name != tt.want {
+				t.Errorf("File.Name = %q, want %q", got.File.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertChunkedBinaryUpload(t *testing.T) {
+	type upload struct {
+		File *File `file:"binary"`
+	}
+
+	tests := []struct {
+		name          string
+		content       string
+		contentLength int64
+		wantSize      int64
+		wantNil       bool
+	}{
+		{name: "unknown length", content: "chunked content", contentLength: -1, wantSize: 15},
+		{name: "known length", content: "fixed", contentLength: 5, wantSize: 5},
+		{name: "empty unknown length", content: "", contentLength: -1, wantNil: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.content))
+			request.ContentLength = tt.contentLength
+			request.Header.Set("Content-Disposition", `attachment; filename="upload.bin"`)
+
+			var got upload
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if tt.wantNil {
+				if got.File != nil {
+					t.Errorf("File = %+v, want nil", got.File)
+				}
+
+				return
+			}
+
+			if got.File == nil {
+				t.Fatal("File = nil, want a file")
+			}
+
+			if got.File.Size != tt.wantSize || string(got.File.Content) != tt.content {
+				t.Errorf("File = %d bytes %q, want %d bytes %q", got.File.Size, got.File.Content, tt.wantSize, tt.content)
+			}
+		})
+	}
+}
+
+func TestConvertIndexedFiles(t *testing.T) {
+	type upload struct {
+		Files []File `file:"files"`
+	}
+
+	tests := []struct {
+		name  string
+		parts []formPart
+		want  []string
+	}{
+		{
+			name: "repeated in submission order",
+			parts: []formPart{
+				{name: "files", filename: "b.txt", content: "b"},
+				{name: "files", filename: "a.txt", content: "a"},
+			},
+			want: []string{"b.txt:b", "a.txt:a"},
+		},
+		{
+			name: "indexed in index order",
+			parts: []formPart{
+				{name: "files[1]", filename: "second.txt", content: "2"},
+				{name: "files[0]", filename: "first.txt", content: "1"},
+				{name: "files[2]", filename: "third.txt", content: "3"},
+			},
+			want: []string{"first.txt:1", "second.txt:2", "third.txt:3"},
+		},
+		{
+			name: "indexed beyond ten",
+			parts: []formPart{
+				{name: "files[10]", filename: "ten.txt", content: "10"},
+				{name: "files[2]", filename: "two.txt", content: "2"},
+			},
+			want: []string{"two.txt:2", "ten.txt:10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got upload
+			if err := Convert(newMultipartRequest(t, tt.parts), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if names := fileNames(got.Files); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Files = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertFileStreams(t *testing.T) {
+	type upload struct {
+		Video   FileStream      `file:"video"`
+		Reader  io.ReadCloser   `file:"reader"`
+		Streams []*FileStream   `file:"streams"`
+		Closers []io.ReadCloser `file:"closers"`
+	}
+
+	large := strings.Repeat("0123456789abcdef", 1<<16)
+
+	tests := []struct {
+		name  string
+		parts []formPart
+		check func(t *testing.T, got upload)
+	}{
+		{
+			name:  "large file stream",
+			parts: []formPart{{name: "video", filename: "movie.mp4", contentType: "video/mp4", content: large}},
+			check: func(t *testing.T, got upload) {
+				if got.Video.Name != "movie.mp4" || got.Video.Size != int64(len(large)) || got.Video.ContentType != "video/mp4" {
+					t.Errorf("Video = %q %d %q, want movie.mp4 %d video/mp4", got.Video.Name, got.Video.Size, got.Video.ContentType, len(large))
+				}
+
+				assertStream(t, got.Video.Reader, large)
+			},
+		},
+		{
+			name:  "read closer",
+			parts: []formPart{{name: "reader", filename: "a.txt", content: "content"}},
+			check: func(t *testing.T, got upload) {
+				assertStream(t, got.Reader, "content")
+			},
+		},
+		{
+			name: "slices",
+			parts: []formPart{
+				{name: "streams", filename: "a.txt", content: "a"},
+				{name: "streams", filename: "b.txt", content: "b"},
+				{name: "closers", filename: "c.txt", content: "c"},
+			},
+			check: func(t *testing.T, got upload) {
+				if len(got.Streams) != 2 || len(got.Closers) != 1 {
+					t.Fatalf("got %d streams and %d closers, want 2 and 1", len(got.Streams), len(got.Closers))
+				}
+
+				assertStream(t, got.Streams[0].Reader, "a")
+				assertStream(t, got.Streams[1].Reader, "b")
+				assertStream(t, got.Closers[0], "c")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got upload
+			if err := NewDecoder(WithMaxMemory(1<<10)).Decode(newMultipartRequest(t, tt.parts), &got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			tt.check(t, got)
+		})
+	}
+}
+
+// assertStream reads and closes reader, checking that it holds want.
+func assertStream(t *testing.T, reader io.ReadCloser, want string) {
+	t.Helper()
+
+	if reader == nil {
+		t.Fatal("reader = nil, want an open file")
+	}
+
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+
+	if string(content) != want {
+		t.Errorf("stream holds %d bytes, want %d", len(content), len(want))
+	}
+}
+
+func TestConvertCompressedBinaryUpload(t *testing.T) {
+	type verbatim struct {
+		File File `file:"binary"`
+	}
+
+	type decompressed struct {
+		File File `file:"binary" decompress:"true"`
+	}
+
+	const content = "plain content, plain content, plain content"
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte(content))
+	_ = writer.Close()
+
+	tests := []struct {
+		name        string
+		destination any
+		file        func(destination any) File
+		want        []byte
+		wantEncoded int64
+	}{
+		{
+			name:        "stored as sent",
+			destination: &verbatim{},
+			file:        func(destination any) File { return destination.(*verbatim).File },
+			want:        compressed.Bytes(),
+		},
+		{
+			name:        "decompressed",
+			destination: &decompressed{},
+			file:        func(destination any) File { return destination.(*decompressed).File },
+			want:        []byte(content),
+			wantEncoded: int64(compressed.Len()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+			request.Header.Set("Content-Encoding", "gzip")
+			request.Header.Set("Content-Disposition", `attachment; filename="a.txt"`)
+
+			if err := Convert(request, tt.destination); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			file := tt.file(tt.destination)
+
+			if !bytes.Equal(file.Content, tt.want) || file.Size != int64(len(tt.want)) {
+				t.Errorf("File = %d bytes %q, want %d bytes %q", file.Size, file.Content, len(tt.want), tt.want)
+			}
+
+			if file.EncodedSize != tt.wantEncoded {
+				t.Errorf("EncodedSize = %d, want %d", file.EncodedSize, tt.wantEncoded)
+			}
+		})
+	}
+}
+
+func TestConvertFileMetadataOnly(t *testing.T) {
+	type upload struct {
+		Archive  File    `file:"archive,meta"`
+		Document *File   `file:"document,meta"`
+		Images   []File  `file:"images,meta"`
+		Limited  File    `file:"limited,meta" maxsize:"4"`
+		Full     File    `file:"full"`
+		Missing  *File   `file:"missing,meta"`
+		Others   []*File `file:"others,meta"`
+	}
+
+	tests := []struct {
+		name    string
+		parts   []formPart
+		check   func(t *testing.T, got upload)
+		wantErr bool
+	}{
+		{
+			name: "metadata without content",
+			parts: []formPart{
+				{name: "archive", filename: "a.zip", contentType: "application/zip", content: "zip content"},
+				{name: "document", filename: "d.pdf", contentType: "application/pdf", content: "pdf"},
+				{name: "images", filename: "1.png", contentType: "image/png", content: "one"},
+				{name: "images", filename: "2.png", contentType: "image/png", content: "two!"},
+				{name: "full", filename: "f.txt", content: "full"},
+			},
+			check: func(t *testing.T, got upload) {
+				assertMetadata(t, got.Archive, "a.zip", 11, "application/zip")
+
+				if got.Document == nil {
+					t.Fatal("Document = nil, want metadata")
+				}
+
+				assertMetadata(t, *got.Document, "d.pdf", 3, "application/pdf")
+
+				if len(got.Images) != 2 {
+					t.Fatalf("len(Images) = %d, want 2", len(got.Images))
+				}
+
+				assertMetadata(t, got.Images[0], "1.png", 3, "image/png")
+				assertMetadata(t, got.Images[1], "2.png", 4, "image/png")
+
+				if string(got.Full.Content) != "full" {
+					t.Errorf("Full.Content = %q, want %q", got.Full.Content, "full")
+				}
+
+				if got.Missing != nil || got.Others != nil {
+					t.Errorf("Missing = %v, Others = %v, want nil", got.Missing, got.Others)
+				}
+			},
+		},
+		{
+			name:    "maxsize applies",
+			parts:   []formPart{{name: "limited", filename: "big.bin", content: "too big"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got upload
+
+			err := Convert(newMultipartRequest(t, tt.parts), &got)
+			if tt.wantErr {
+				var sizeErr *FileSizeError
+				if !errors.As(err, &sizeErr) {
+					t.Fatalf("Convert() error = %v, want a *FileSizeError", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			tt.check(t, got)
+		})
+	}
+}
+
+// assertMetadata checks that file holds the given metadata and no content.
+func assertMetadata(t *testing.T, file File, name string, size int64, contentType string) {
+	t.Helper()
+
+	if file.Name != name || file.Size != size || file.ContentType != contentType {
+		t.Errorf("File = %q %d %q, want %q %d %q", file.Name, file.Size, file.ContentType, name, size, contentType)
+	}
+
+	if file.Content != nil {
+		t.Errorf("Content = %q, want nil", file.Content)
+	}
+}
+
+func TestConvertFileAccept(t *testing.T) {
+	type upload struct {
+		Avatar File `file:"avatar" accept:"image/png,image/jpeg"`
+		Photo  File `file:"photo" accept:"image/*"`
+	}
+
+	const png = "\x89PNG\r\n\x1a\n0000"
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		part     formPart
+		wantType string
+	}{
+		{
+			name: "accepted",
+			part: formPart{name: "avatar", filename: "me.png", contentType: "image/png", content: png},
+		},
+		{
+			name:     "rejected",
+			part:     formPart{name: "avatar", filename: "me.txt", contentType: "text/plain", content: "hello"},
+			wantType: "text/plain",
+		},
+		{
+			name: "wildcard accepted",
+			part: formPart{name: "photo", filename: "me.gif", contentType: "image/gif", content: "GIF89a"},
+		},
+		{
+			name:     "wildcard rejected",
+			part:     formPart{name: "photo", filename: "me.pdf", contentType: "application/pdf", content: "%PDF-1.4"},
+			wantType: "application/pdf",
+		},
+		{
+			name: "false type without sniffing",
+			part: formPart{name: "avatar", filename: "me.png", contentType: "image/png", content: "<html></html>"},
+		},
+		{
+			name:     "false type with sniffing",
+			opts:     []Option{WithContentSniffing()},
+			part:     formPart{name: "avatar", filename: "me.png", contentType: "image/png", content: "<html></html>"},
+			wantType: "text/html",
+		},
+		{
+			name: "true type with sniffing",
+			opts: []Option{WithContentSniffing()},
+			part: formPart{name: "avatar", filename: "me.png", contentType: "image/png", content: png},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got upload
+
+			err := NewDecoder(tt.opts...).Decode(newMultipartRequest(t, []formPart{tt.part}), &got)
+
+			var typeErr *FileTypeError
+			if (tt.wantType != "") != errors.As(err, &typeErr) {
+				t.Fatalf("Decode() error = %v, want type %q", err, tt.wantType)
+			}
+
+			if tt.wantType == "" && err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if typeErr != nil && typeErr.ContentType != tt.wantType {
+				t.Errorf("ContentType = %q, want %q", typeErr.ContentType, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestConvertBinaryStream(t *testing.T) {
+	type reader struct {
+		Body io.Reader `file:"binary"`
+	}
+
+	type readCloser struct {
+		Body io.ReadCloser `file:"binary"`
+	}
+
+	tests := []struct {
+		name        string
+		destination any
+		stream      func(destination any) io.Reader
+	}{
+		{
+			name:        "reader",
+			destination: &reader{},
+			stream:      func(destination any) io.Reader { return destination.(*reader).Body },
+		},
+		{
+			name:        "read closer",
+			destination: &readCloser{},
+			stream:      func(destination any) io.Reader { return destination.(*readCloser).Body },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The content is only written once Convert has returned, so the
+			// field must read the body itself rather than a buffered copy.
+			body, writer := io.Pipe()
+
+			request := httptest.NewRequest(http.MethodPost, "/", body)
+			request.ContentLength = -1
+			request.Header.Set("Content-Type", "application/octet-stream")
+
+			if err := Convert(request, tt.destination); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			go func() {
+				_, _ = io.WriteString(writer, "streamed content")
+				_ = writer.Close()
+			}()
+
+			stream := tt.stream(tt.destination)
+			if stream == nil {
+				t.Fatal("stream = nil, want the request body")
+			}
+
+			content, err := io.ReadAll(stream)
+			if err != nil {
+				t.Fatalf("failed to read stream: %v", err)
+			}
+
+			if string(content) != "streamed content" {
+				t.Errorf("stream = %q, want %q", content, "streamed content")
+			}
+		})
+	}
+}
+
+func TestConvertBinaryStreamWithoutBody(t *testing.T) {
+	type optional struct {
+		Body io.Reader `file:"binary"`
+	}
+
+	type required struct {
+		Body io.ReadCloser `file:"binary" required:"true"`
+	}
+
+	var got optional
+	if err := Convert(httptest.NewRequest(http.MethodPost, "/", nil), &got); err != nil || got.Body != nil {
+		t.Errorf("Convert() = %v, %v, want nil body and no error", got.Body, err)
+	}
+
+	var requiredErr *RequiredError
+	if err := Convert(httptest.NewRequest(http.MethodPost, "/", nil), &required{}); !errors.As(err, &requiredErr) {
+		t.Errorf("Convert() error = %v, want a *RequiredError", err)
+	}
+}
+
+// openFiles returns the number of file descriptors open in the process, and
+// skips t where /proc is not available.
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open files: %v", err)
+	}
+
+	return len(entries)
+}
+
+func TestConvertManyFilesClosed(t *testing.T) {
+	type upload struct {
+		Avatar    File    `file:"avatar"`
+		Cover     *File   `file:"cover"`
+		Documents []File  `file:"documents"`
+		Scans     []*File `file:"scans"`
+	}
+
+	parts := []formPart{
+		{name: "avatar", filename: "avatar.png", content: "avatar"},
+		{name: "cover", filename: "cover.png", content: "cover"},
+	}
+
+	for i := range 20 {
+		parts = append(parts,
+			formPart{name: "documents", filename: fmt.Sprintf("doc%d.txt", i), content: strings.Repeat("d", 64)},
+			formPart{name: "scans", filename: fmt.Sprintf("scan%d.txt", i), content: strings.Repeat("s", 64)},
+		)
+	}
+
+	// A tiny memory limit stores every file on disk, so each one read
+	// opens a descriptor.
+	request := newMultipartRequest(t, parts)
+	if err := request.ParseMultipartForm(1); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = request.MultipartForm.RemoveAll() })
+
+	before := openFiles(t)
+
+	var got upload
+	if err := Convert(request, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	if after := openFiles(t); after != before {
+		t.Errorf("open files = %d after Convert(), want %d", after, before)
+	}
+
+	if string(got.Avatar.Content) != "avatar" || got.Cover == nil || string(got.Cover.Content) != "cover" {
+		t.Errorf("Avatar = %q, Cover = %v, want avatar and cover", got.Avatar.Content, got.Cover)
+	}
+
+	if len(got.Documents) != 20 || len(got.Scans) != 20 {
+		t.Fatalf("got %d documents and %d scans, want 20 of each", len(got.Documents), len(got.Scans))
+	}
+
+	if string(got.Scans[19].Content) != strings.Repeat("s", 64) {
+		t.Errorf("Scans[19].Content = %q, want 64 bytes of s", got.Scans[19].Content)
+	}
+}
@@ -2,283 +2,750 @@
 // into Go struct fields using struct tags.
 //
 // It supports mapping from various sources:
-// - JSON request body
+// - JSON and XML request body
 // - Form fields
 // - URL query parameters
 // - Path parameters
 // - HTTP headers
+// - HTTP cookies
 // - File uploads (both multipart and binary)
 package http2struct
 
 import (
+	"cmp"
+	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	urlType      = reflect.TypeOf(url.URL{})
+	numberType   = reflect.TypeOf(json.Number(""))
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	readerType          = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType      = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+
+	requestUnmarshalerType = reflect.TypeOf((*RequestUnmarshaler)(nil)).Elem()
+)
+
+var (
+	// ErrNotPointer is returned when the destination is not a pointer.
+	ErrNotPointer = errors.New("destination must be a pointer")
+
+	// ErrNotStruct is returned when the destination does not point to a
+	// struct.
+	ErrNotStruct = errors.New("destination must be a struct")
+
+	// ErrUnsupportedKind is wrapped by the errors returned for fields, slice
+	// elements and map keys whose type cannot be converted from a request
+	// value.
+	ErrUnsupportedKind = errors.New("unsupported kind")
+
+	// ErrMissingFile matches, through errors.Is, the *RequiredError returned
+	// when a required file field receives no upload.
+	ErrMissingFile = errors.New("missing file")
+
+	// ErrUnknownQuery is wrapped by the error returned by Decoders created
+	// with WithDisallowUnknownQuery for query parameters no field consumes.
+	ErrUnknownQuery = errors.New("unknown query parameter")
+
+	// ErrEmptyValue is wrapped by the *ConvertError returned by Decoders
+	// created with WithEmptyValues(EmptyError) for keys present with an empty
+	// value.
+	ErrEmptyValue = errors.New("empty value")
 )
 
 // File represents an uploaded file from an HTTP request
 type File struct {
-	Name    string // Original filename provided by the client
-	Size    int64  // Size of the file in bytes
-	Content []byte // Raw content of the file
+	Name        string // Original filename provided by the client
+	Size        int64  // Size of the file in bytes
+	ContentType string // MIME type declared by the client, if any
+	Content     []byte // Raw content of the file
+	EncodedSize int64  // Size in bytes of a binary upload as sent, when it was decompressed
+}
+
+// TagConflictError is returned by Decoders created with WithStrictTags when a
+// field carries more than one source tag.
+type TagConflictError struct {
+	Field   string   // Name of the struct field
+	Sources []string // Sources the field has tags for, in order of precedence
+}
+
+func (e *TagConflictError) Error() string {
+	return fmt.Sprintf("conflicting source tags %s for %q field", strings.Join(e.Sources, ", "), e.Field)
+}
+
+// FileStream represents an uploaded file from a multipart form that is left
+// open for streaming rather than read into memory. The caller is responsible
+// for closing Reader.
+type FileStream struct {
+	Name        string        // Original filename provided by the client
+	Size        int64         // Size of the file in bytes
+	ContentType string        // MIME type declared by the client, if any
+	Reader      io.ReadCloser // Open file content
+}
+
+// RequiredError is returned when a field tagged `required:"true"` receives no
+// value from its source.
+type RequiredError struct {
+	Field  string // Name of the struct field
+	Source string // Source of the value: form, file, header, query, path, cookie, trailer, meta, auth, context, body or source
+	Name   string // Name of the value within its source
+}
+
+func (e *RequiredError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%s is required for %q field", e.Source, e.Field)
+	}
+
+	return fmt.Sprintf("%s %q is required for %q field", e.Source, e.Name, e.Field)
+}
+
+// Is reports whether target is ErrMissingFile and e is about a file field.
+func (e *RequiredError) Is(target error) bool {
+	return target == ErrMissingFile && e.Source == sourceFile
+}
+
+// MessageError is returned in place of the error of a field tagged
+// `msg:"..."`, carrying that message for display to users. Its Err is the
+// original error, so errors.Is and errors.As see through it.
+type MessageError struct {
+	Field   string // Name of the struct field
+	Message string // Message given by the field's msg tag
+	Err     error  // Underlying error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("%q field: %s", e.Field, e.Message)
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// ConvertError is returned when a value from the request cannot be converted
+// into its field. Its Err is the underlying cause, so errors.Is and errors.As
+// see through it. Missing required values are reported as a *RequiredError
+// instead, whatever their source.
+type ConvertError struct {
+	Field  string // Name of the struct field, empty for the decoded body
+	Tag    string // Name of the value within its source, as given by the tag
+	Source string // Source of the value: form, file, header, query, path, cookie, trailer, meta, auth, context, body or source
+	Err    error  // Underlying error
+}
+
+func (e *ConvertError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("failed to convert %s: %v", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("failed to convert %q %s to %q field: %v", e.Tag, e.Source, e.Field, e.Err)
+}
+
+func (e *ConvertError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError wraps err, returned for the value name of source while
+// converting it into field, in a *ConvertError. A *RequiredError is returned
+// as is, since it already names them.
+func fieldError(field, name, source string, err error) error {
+	if _, ok := err.(*RequiredError); ok {
+		return err
+	}
+
+	return &ConvertError{Field: field, Tag: name, Source: source, Err: err}
+}
+
+// Errors is returned by decoders created with WithCollectErrors, listing the
+// error of every field that failed to decode in declaration order.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Validator is implemented by destinations that check their own fields.
+// Convert calls Validate once every field has been populated without error
+// and returns its error wrapped.
+type Validator interface {
+	Validate() error
+}
+
+// RequestUnmarshaler is implemented by types that decode a request themselves.
+// Destinations implementing it are handed the request instead of being mapped
+// from their tags, and so are fields implementing it, whatever their tags.
+type RequestUnmarshaler interface {
+	UnmarshalRequest(request *http.Request) error
+}
+
+// ContextKey is the type of the request context keys read by fields tagged
+// `context:"name"`, which read the value stored under ContextKey("name").
+type ContextKey string
+
+// metaValues maps the keys of the meta tag to the request metadata they read.
+var metaValues = map[string]func(*http.Request) string{
+	"method":     func(r *http.Request) string { return r.Method },
+	"host":       func(r *http.Request) string { return r.Host },
+	"remoteaddr": func(r *http.Request) string { return r.RemoteAddr },
+	"path":       func(r *http.Request) string { return r.URL.Path },
+	"rawquery":   func(r *http.Request) string { return r.URL.RawQuery },
+}
+
+// authValues maps the keys of the auth tag to the credentials they read from
+// the Authorization header.
+var authValues = map[string]func(*http.Request) string{
+	"username": func(r *http.Request) string {
+		username, _, _ := r.BasicAuth()
+
+		return username
+	},
+	"password": func(r *http.Request) string {
+		_, password, _ := r.BasicAuth()
+
+		return password
+	},
+	"bearer": func(r *http.Request) string {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+
+		return strings.TrimSpace(token)
+	},
 }
 
 // Convert maps data from an HTTP request into a struct.
-// The destination must be a pointer to a struct with appropriate tags.
+// The destination must be a pointer to a struct with appropriate tags, or a
+// pointer to a slice, which only receives the decoded body, such as a JSON
+// array of objects.
 //
 // Supported struct tags:
-// - `json:"field_name"` - Maps JSON body fields
-// - `form:"field_name"` - Maps form fields
+// - `json:"field_name"` - Maps JSON body fields. Fields populated only from
+// the body are never reset afterwards, so json.RawMessage fields keep the
+// exact bytes of their part of the body for deferred decoding. Exported
+// fields without any source tag are left to the body decoder too, whatever
+// tags it understands, so the body is decoded for them unless they are tagged
+// `json:"-"`, `xml:"-"` or `yaml:"-"`.
+// - `xml:"field_name"` - Maps XML body fields (application/xml or text/xml)
+// - `form:"field_name"` - Maps form fields from a multipart/form-data or
+// application/x-www-form-urlencoded request body (URL query values are only
+// read through the query tag)
 // - `query:"param_name"` - Maps URL query parameters
-// - `path:"param_name"` - Maps URL path parameters
-// - `header:"Header-Name"` - Maps HTTP headers
-// - `file:"field_name"` - Maps uploaded files from multipart forms
-// - `file:"binary"` - Maps the entire request body as a file
+// - `path:"param_name"` - Maps URL path parameters. Slice fields receive the
+// slash-separated segments of catch-all wildcards such as {path...}.
+// - `header:"Header-Name"` - Maps HTTP headers. A map field tagged
+// `header:"*"` receives every header keyed by its canonical name; a
+// map[string][]string or http.Header field keeps each line as sent.
+// - `cookie:"cookie_name"` - Maps HTTP cookies
+// - `trailer:"Trailer-Name"` - Maps HTTP trailers. Trailers only arrive once
+// the body has been read to the end, so these fields are populated after all
+// others, reading whatever is left of the body first; they stay empty while
+// the body is left unread for streaming.
+// - `meta:"key"` - Maps request metadata: method, host, remoteaddr, path
+// (the URL path) or rawquery
+// - `auth:"username"` and `auth:"password"` - Map HTTP Basic Auth credentials,
+// left empty when the Authorization header does not use the Basic scheme
+// - `auth:"bearer"` - Maps the token of an "Authorization: Bearer <token>"
+// header, left empty for other schemes
+// - `context:"name"` - Maps the request context value stored under
+// ContextKey("name"), assigning it when its type is assignable to the field
+// and converting it like other values when it is a string
+// - `source:"header:X-Tenant,query:tenant"` - Maps the first non-empty value
+// among the listed header, trailer, query, form, path, cookie, meta or auth
+// values, in order, falling back to the default and required tags when none
+// has one
+// - `body:""` - Maps the raw request body into a string, []byte or
+// json.RawMessage field, whatever its Content-Type, such as text/plain. The
+// body is read once and still decoded into the json/xml fields of the same
+// struct.
+// - `file:"field_name"` - Maps uploaded files from multipart forms into File,
+// *File, []File or []*File fields. Slices collect files repeated under the
+// name in submission order, then files under indexed names such as
+// "field_name[0]" in index order. FileStream, *FileStream and io.ReadCloser
+// fields, or slices of them, receive the files open instead of read into
+// memory; the caller must close them, even when Convert returns an error, and
+// must read them before the multipart form is removed. With the meta option,
+// as in `file:"field_name,meta"`, File fields receive the name, size and
+// content type of the upload while Content stays nil.
+// - `file:"binary"` - Maps the entire request body as a file. File and *File
+// fields buffer the whole body in memory; io.Reader and io.ReadCloser fields
+// receive the request body itself so that large uploads can be streamed.
+// Streamed bodies are read by the caller after Convert returns. Bodies sent
+// with a gzip or deflate Content-Encoding are kept as sent, unless the field is
+// tagged `decompress:"true"`; File.EncodedSize then holds the size as sent.
+//
+// Fields of type any, or another interface, are only populated from the body,
+// like encoding/json does, or from context values; other source tags on them
+// fail with ErrUnsupportedKind, leaving a decoded body value in place. The same
+// goes for slices of structs, such as []Item, which only the body, files and
+// context values can fill.
+//
+// Fields of type []byte are decoded from standard or URL-safe base64, like
+// encoding/json does. Other slice and array fields split a single value on
+// commas, or on the separator given in the `delim:"|"` tag. The `trim:"true"`
+// tag trims whitespace around each element and the `skipempty:"true"` tag
+// drops empty elements. Elements convert like fields of their type, so slices
+// of times, pointers or encoding.TextUnmarshaler types work alike. Slices of
+// slices, such as [][]int, take one inner slice per repeated value. Query
+// slices sent with indexed keys, as in "?items[2]=c&items[0]=a", place each
+// value at its index, leaving missing indices zero; indices above 1000 are
+// rejected.
+//
+// Fields of type time.Time or *time.Time are parsed with the layout given in
+// the `timeformat:"layout"` tag, or time.RFC3339 when the tag is absent. The
+// layouts "unix" and "unixmilli" read integer seconds or milliseconds since the
+// Unix epoch instead.
+// Fields of type time.Duration accept time.ParseDuration strings such as "1h30m"
+// as well as plain integer nanoseconds, and fields of type url.URL or *url.URL
+// are parsed with url.Parse. Fields of type json.Number keep the value as given,
+// without going through a float, after checking it is a valid JSON number. Any
+// other field type implementing encoding.TextUnmarshaler is populated through
+// its UnmarshalText method, and
+// types defined over supported ones, such as `type Status string` or
+// `type Date time.Time`, convert like their underlying type. Types with a
+// converter added by RegisterConverter use it before any of the above.
+//
+// Struct fields tagged with query or form are populated field by field from
+// keys prefixed with the field's name and a dot, so `query:"address"` maps its
+// `query:"city"` field from "address.city". Pointers to such structs are only
+// allocated when at least one prefixed key is present.
+//
+// The fields of embedded structs are mapped as if declared on the outer
+// struct. Embedded pointers are allocated only when one of their fields
+// receives a value.
+//
+// Map fields with string keys tagged `query:"*"` capture every query
+// parameter, while `query:"filter"` captures bracketed keys such as
+// "filter[status]" under the key "status". Form fields are captured the same
+// way with the form tag. Map values are converted like any other field, so
+// map[string][]string keeps every value of repeated keys.
+//
+// Pointer fields such as *int or *string are allocated only when the source
+// provides a non-empty value, so a nil pointer means the value was absent or
+// empty. Decoders created with WithEmptyPointers also allocate them, pointing
+// to the zero value, for keys present with an empty value. WithEmptyValues
+// configures whether empty values are otherwise skipped, rejected or replaced
+// with the `default` tag.
+//
+// String fields, and the string elements of slices, arrays and maps, tagged
+// with a space-separated `oneof:"draft published archived"` set only accept
+// the listed values, and those tagged `pattern:"^[a-z0-9-]+$"` only accept
+// values matching the regular expression, which is compiled once.
+//
+// Int, uint and float fields tagged `min:"1"` or `max:"100"` reject values
+// outside those bounds.
+//
+// Bool fields tagged `flag:"true"` are set to true when their key is present
+// without a value, as in "?verbose" or "?verbose=", while "?verbose=false"
+// still sets them to false.
+//
+// Header fields tagged `list:"true"` treat headers as comma-separated lists
+// with optional parameters, so "Accept-Language: en;q=0.9, fr;q=0.8" yields
+// the elements "en" and "fr", in the order sent. Other headers are taken as
+// whole lines, split on commas only for slice fields.
+//
+// The `default:"value"` tag supplies a value for form, query, header, path, and
+// cookie fields when the request does not carry one. The `required:"true"` tag
+// makes Convert return a *RequiredError when the value (or uploaded file) is
+// missing.
+//
+// File fields may restrict the accepted content types with a comma-separated
+// `accept:"image/png,image/*"` tag; other uploads fail with a *FileTypeError.
+// The `maxsize:"5MB"` tag caps the size of each file (accepting B, KB, MB and GB
+// suffixes in binary multiples), rejecting larger ones with a *FileSizeError
+// before they are read into memory.
+//
+// The errors of fields tagged `msg:"Please provide a valid email"` are returned
+// as a *MessageError carrying that message in place of the original error.
+//
+// Destinations and fields implementing RequestUnmarshaler decode the request
+// themselves through UnmarshalRequest, bypassing their tags.
 func Convert(request *http.Request, destination any) error {
-	if request == nil {
-		return fmt.Errorf("request cannot be nil")
-	}
+	return defaultDecoder.Decode(request, destination)
+}
 
-	destinationType := reflect.TypeOf(destination)
+// ConvertWithOptions works like Convert, with its behavior adjusted by the
+// given options. Callers converting many requests with the same options should
+// create a Decoder once with NewDecoder instead.
+func ConvertWithOptions(request *http.Request, destination any, opts ...Option) error {
+	return NewDecoder(opts...).Decode(request, destination)
+}
 
-	if destinationType == nil {
-		return fmt.Errorf("destination cannot be nil")
-	}
+// ConvertWithReport works like Convert and also returns a Report of the fields
+// that received a value from the request, as opposed to those left zero or
+// set from their `default` tag, which suits building partial updates for
+// PATCH handlers. A field is reported when its source carries its key, even
+// with an empty value. Fields decoded from a JSON body are reported when the
+// top-level object has their key; fields of nested JSON objects are not
+// reported individually. The report also carries the exact body bytes handed
+// to the body decoder, read only once, for checking signatures over them.
+func ConvertWithReport(request *http.Request, destination any) (Report, error) {
+	return defaultDecoder.DecodeWithReport(request, destination)
+}
 
-	if destinationType.Kind() != reflect.Ptr {
-		return fmt.Errorf("destination must be a pointer")
-	}
+// ConvertValues maps values into the fields of a struct tagged with query, as
+// if they were the query parameters of a request, without an http.Request.
+// Fields with a source tag read the query parameters it lists, and fields with
+// other source tags are left untouched.
+func ConvertValues(values url.Values, destination any) error {
+	return defaultDecoder.DecodeValues(values, destination)
+}
+
+// Decode allocates a T, populates it from the request like Convert, and
+// returns it. T must be a struct type, or a slice type for array bodies.
+//
+//	req, err := http2struct.Decode[CreateUserRequest](r)
+func Decode[T any](request *http.Request) (T, error) {
+	var destination T
+
+	err := Convert(request, &destination)
 
-	destinationType = destinationType.Elem()
+	return destination, err
+}
 
-	if destinationType.Kind() != reflect.Struct {
-		return fmt.Errorf("destination must be a struct")
+// MustConvert is like Convert but panics if the request cannot be converted.
+// It simplifies tests and handlers whose requests are known to be valid.
+func MustConvert(request *http.Request, destination any) {
+	if err := Convert(request, destination); err != nil {
+		panic(fmt.Errorf("http2struct: MustConvert: %w", err))
 	}
+}
 
-	if err := convertBody(request, destination, destinationType); err != nil {
-		return fmt.Errorf("failed to convert body: %w", err)
+// MustDecode is like Decode but panics if the request cannot be decoded.
+func MustDecode[T any](request *http.Request) T {
+	destination, err := Decode[T](request)
+	if err != nil {
+		panic(fmt.Errorf("http2struct: MustDecode: %w", err))
 	}
 
-	v := reflect.ValueOf(destination).Elem()
+	return destination
+}
 
-	for i := range destinationType.NumField() {
-		field := destinationType.Field(i)
+func isRequired(field reflect.StructField) bool {
+	required, _ := strconv.ParseBool(field.Tag.Get("required"))
 
-		if !field.IsExported() {
-			continue
-		}
+	return required
+}
 
-		fieldValue := v.Field(i)
+// isRequestUnmarshaler reports whether values of t, or pointers to them,
+// implement RequestUnmarshaler.
+func isRequestUnmarshaler(t reflect.Type) bool {
+	return t.Kind() != reflect.Interface && (t.Implements(requestUnmarshalerType) || reflect.PointerTo(t).Implements(requestUnmarshalerType))
+}
 
-		if !fieldValue.CanSet() {
-			continue
-		}
+// holdsEmpty reports whether t, or the elements of t when it is a slice or
+// array, are strings, for which an empty value is a value like any other.
+func (d *Decoder) holdsEmpty(t reflect.Type) bool {
+	t = indirect(t)
 
-		fieldValue.SetZero()
+	if !d.isScalar(t) {
+		t = indirect(t.Elem())
+	}
 
-		tag, ok := field.Tag.Lookup("form")
-		if ok && tag != "" && tag != "-" {
-			if request.PostForm == nil {
-				if err := request.ParseMultipartForm(32 << 20); err != nil {
-					return fmt.Errorf("failed to parse request multipart form: %w", err)
-				}
-			}
+	return t.Kind() == reflect.String
+}
 
-			var v string
+// isFlag reports whether field is a bool, or pointer to bool, tagged
+// `flag:"true"`, which is set to true by a key present without a value.
+func isFlag(field reflect.StructField) bool {
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
 
-			if p := request.PostForm[tag]; len(p) > 0 {
-				v = p[0]
-			}
+	flag, _ := strconv.ParseBool(field.Tag.Get("flag"))
 
-			if err := convert(fieldValue, field.Type, v); err != nil {
-				return fmt.Errorf("failed to convert %q form to %q field: %w", tag, field.Name, err)
-			}
+	return flag && fieldType.Kind() == reflect.Bool
+}
 
-			continue
+// convertField converts values into fieldValue, falling back to the `default`
+// tag when no value is given. Pointer fields only receive the default when the
+// source did not provide the value at all, so an explicitly empty value keeps
+// them nil, or points them to the zero value with WithEmptyPointers. Empty
+// values are handled as configured by WithEmptyValues. Slice fields receive
+// every value when more than one is given; otherwise the first value is
+// converted, splitting it on commas for slices.
+func (d *Decoder) convertField(fieldValue reflect.Value, field reflect.StructField, source, name string, values []string, present bool) error {
+	if d.trimSpace {
+		trimmed := make([]string, len(values))
+
+		for i, value := range values {
+			trimmed[i] = strings.TrimSpace(value)
 		}
 
-		tag, ok = field.Tag.Lookup("file")
-		if ok && tag != "" && tag != "-" && tag != "binary" {
-			if field.Type.Kind() != reflect.Pointer && field.Type != reflect.TypeOf(File{}) {
-				return fmt.Errorf("%q type is not supported for %q field", fieldValue.Type().String(), field.Name)
-			}
-
-			if field.Type.Kind() == reflect.Pointer && field.Type != reflect.TypeOf(&File{}) {
-				return fmt.Errorf("%q type is not supported for %q field", fieldValue.Type().String(), field.Name)
-			}
-
-			base, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+		values = trimmed
+	}
 
-			if strings.TrimSpace(base) != "multipart/form-data" {
-				continue
-			}
+	var value string
 
-			file, fileHeader, err := request.FormFile(tag)
-			if errors.Is(err, http.ErrMissingFile) {
-				continue
-			}
-			if err != nil {
-				return fmt.Errorf("failed to get %q form file for %q field: %w", tag, field.Name, err)
-			}
+	if len(values) > 0 {
+		value = values[0]
+	}
 
-			defer file.Close()
+	if value == "" && present && isFlag(field) {
+		return d.convert(fieldValue, field.Type, field.Tag, "true")
+	}
 
-			content, err := io.ReadAll(file)
-			if err != nil {
-				return fmt.Errorf("failed to read %q form file content for %q field: %w", tag, field.Name, err)
+	if value == "" {
+		// Empty values take the default, unless they keep a pointer nil or
+		// are rejected below.
+		useDefault := !present || d.emptyValues == EmptyDefault || (d.emptyValues == EmptySkip && field.Type.Kind() != reflect.Pointer)
+
+		def, ok := field.Tag.Lookup("default")
+		if ok && useDefault && (!d.merge || fieldValue.IsZero()) {
+			if err := d.convert(fieldValue, field.Type, field.Tag, def); err != nil {
+				return fmt.Errorf("failed to convert %q default value: %w", def, err)
 			}
 
-			f := File{
-				Name:    fileHeader.Filename,
-				Size:    fileHeader.Size,
-				Content: content,
-			}
+			return nil
+		}
 
-			if field.Type.Kind() == reflect.Pointer {
-				fieldValue.Set(reflect.ValueOf(&f))
+		if isRequired(field) {
+			return &RequiredError{Field: field.Name, Source: source, Name: name}
+		}
 
-				continue
-			}
+		if present && d.emptyValues == EmptyError && !d.holdsEmpty(field.Type) {
+			return ErrEmptyValue
+		}
 
-			fieldValue.Set(reflect.ValueOf(f))
+		if present && d.emptyPointers && field.Type.Kind() == reflect.Pointer {
+			fieldValue.Set(reflect.New(field.Type.Elem()))
 
-			continue
+			return nil
 		}
+	}
 
-		tag, ok = field.Tag.Lookup("file")
-		if ok && tag == "binary" {
-			if field.Type.Kind() != reflect.Pointer && field.Type != reflect.TypeOf(File{}) {
-				return fmt.Errorf("%q type is not supported for %q field", fieldValue.Type().String(), field.Name)
-			}
+	return d.convertValues(fieldValue, field.Type, field.Tag, values)
+}
 
-			if field.Type.Kind() == reflect.Pointer && field.Type != reflect.TypeOf(&File{}) {
-				return fmt.Errorf("%q type is not supported for %q field", fieldValue.Type().String(), field.Name)
-			}
+// convertValues converts values into field. Slice fields receive every value
+// when more than one is given; otherwise the first value is converted.
+func (d *Decoder) convertValues(field reflect.Value, fieldType reflect.Type, tag reflect.StructTag, values []string) error {
+	if len(values) > 1 && fieldType.Kind() == reflect.Slice && !d.isScalar(fieldType) {
+		return d.convertSlice(field, fieldType, tag, values)
+	}
 
-			if request.ContentLength == 0 {
-				return nil
-			}
+	if len(values) > 1 && fieldType.Kind() == reflect.Array && !d.isScalar(fieldType) {
+		return d.convertArray(field, fieldType, tag, values)
+	}
 
-			contentDisposition := request.Header.Get("Content-Disposition")
+	var value string
 
-			_, params, err := mime.ParseMediaType(contentDisposition)
-			if err != nil {
-				continue
-			}
+	if len(values) > 0 {
+		value = values[0]
+	}
 
-			filename := params["filename"]
-			if filename == "" {
-				filename = params["filename*"]
-			}
+	return d.convert(field, fieldType, tag, value)
+}
 
-			if filename == "" {
-				continue
-			}
+// convertMap populates a map field from values. With the name "*" every key
+// starting with prefix is captured, without the prefix; otherwise only keys of
+// the form prefix+name+"[key]" are, keyed by the part in brackets. Map values
+// are converted like fields, so map[string][]T collects repeated keys. The map
+// is left nil when no key matches.
+func (d *Decoder) convertMap(field reflect.Value, fieldType reflect.Type, tag reflect.StructTag, values url.Values, prefix, name string) error {
+	if fieldType.Key().Kind() != reflect.String {
+		return fmt.Errorf("%w %q for map key", ErrUnsupportedKind, fieldType.Key().Kind().String())
+	}
 
-			content, err := io.ReadAll(request.Body)
-			if err != nil {
-				return fmt.Errorf("failed to read %q raw body for %q field: %w", tag, field.Name, err)
-			}
+	m := reflect.MakeMap(fieldType)
 
-			f := File{
-				Name:    filename,
-				Size:    request.ContentLength,
-				Content: content,
-			}
+	for key, vs := range values {
+		mapKey, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
 
-			if field.Type.Kind() == reflect.Pointer {
-				fieldValue.Set(reflect.ValueOf(&f))
+		if name != "*" {
+			if mapKey, ok = strings.CutPrefix(mapKey, name+"["); !ok {
+				continue
+			}
 
+			if mapKey, ok = strings.CutSuffix(mapKey, "]"); !ok {
 				continue
 			}
+		}
 
-			fieldValue.Set(reflect.ValueOf(f))
+		element := reflect.New(fieldType.Elem()).Elem()
 
-			continue
+		if err := d.convertValues(element, fieldType.Elem(), tag, vs); err != nil {
+			return fmt.Errorf("failed to convert map value for %q key: %w", mapKey, err)
 		}
 
-		tag, ok = field.Tag.Lookup("header")
-		if ok && tag != "" && tag != "-" {
-			v := request.Header.Get(tag)
+		m.SetMapIndex(reflect.ValueOf(mapKey).Convert(fieldType.Key()), element)
+	}
 
-			if err := convert(fieldValue, field.Type, v); err != nil {
-				return fmt.Errorf("failed to convert %q header to %q field: %w", tag, field.Name, err)
-			}
+	if m.Len() > 0 {
+		field.Set(m)
+	}
 
+	return nil
+}
+
+// maxIndex is the largest index accepted in keys such as name[3], bounding the
+// length of the slices that indexed keys can make a request allocate.
+const maxIndex = 1000
+
+// indexedValues returns the values of keys of the form name+"[index]", placed
+// at their index whatever the order they were sent in, along with the keys.
+// Indices missing in between are left empty, so their elements stay zero, and
+// only the first value of a repeated index is kept. The result is nil when no
+// key matches.
+func indexedValues(values url.Values, name string) ([]string, []string, error) {
+	var (
+		elements []string
+		keys     []string
+	)
+
+	for key, vs := range values {
+		i, ok := strings.CutPrefix(key, name+"[")
+		if !ok {
 			continue
 		}
 
-		tag, ok = field.Tag.Lookup("query")
-		if ok && tag != "" && tag != "-" {
-			v := request.URL.Query().Get(tag)
-
-			if err := convert(fieldValue, field.Type, v); err != nil {
-				return fmt.Errorf("failed to convert %q query to %q field: %w", tag, field.Name, err)
-			}
+		i, ok = strings.CutSuffix(i, "]")
+		if !ok {
+			continue
+		}
 
+		index, err := strconv.Atoi(i)
+		if err != nil || index < 0 {
 			continue
 		}
 
-		tag, ok = field.Tag.Lookup("path")
-		if ok && tag != "" && tag != "-" {
-			v := request.PathValue(tag)
+		if index > maxIndex {
+			return nil, nil, fmt.Errorf("index %d of %q exceeds maximum of %d", index, name, maxIndex)
+		}
 
-			if err := convert(fieldValue, field.Type, v); err != nil {
-				return fmt.Errorf("failed to convert %q path to %q field: %w", tag, field.Name, err)
-			}
+		if index >= len(elements) {
+			elements = append(elements, make([]string, index+1-len(elements))...)
+		}
 
-			continue
+		if len(vs) > 0 {
+			elements[index] = vs[0]
 		}
+
+		keys = append(keys, key)
 	}
 
-	return nil
+	return elements, keys, nil
 }
 
-func convertBody(request *http.Request, destination any, destinationType reflect.Type) error {
-	if request.ContentLength == 0 {
+func (d *Decoder) convert(field reflect.Value, fieldType reflect.Type, tag reflect.StructTag, value string) error {
+	if value == "" {
 		return nil
 	}
 
-	base, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+	if converter, ok := lookupConverter(fieldType); ok {
+		return convertRegistered(field, fieldType, converter, value)
+	}
+
+	if fieldType.Kind() == reflect.Pointer {
+		v := reflect.New(fieldType.Elem())
+
+		if err := d.convert(v.Elem(), fieldType.Elem(), tag, value); err != nil {
+			return err
+		}
+
+		field.Set(v)
 
-	if strings.TrimSpace(base) != "application/json" {
 		return nil
 	}
 
-	for i := range destinationType.NumField() {
-		field := destinationType.Field(i)
+	switch baseType(fieldType) {
+	case timeType:
+		layout := tag.Get("timeformat")
+		if layout == "" {
+			layout = time.RFC3339
+		}
 
-		if !field.IsExported() {
-			continue
+		if layout == "unix" || layout == "unixmilli" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse value to %s time: %w", layout, err)
+			}
+
+			v := time.Unix(n, 0)
+			if layout == "unixmilli" {
+				v = time.UnixMilli(n)
+			}
+
+			field.Set(reflect.ValueOf(v.In(d.location)).Convert(fieldType))
+
+			return nil
 		}
 
-		tag, ok := field.Tag.Lookup("json")
-		if !ok {
-			continue
+		v, err := time.ParseInLocation(layout, value, d.location)
+		if err != nil {
+			return fmt.Errorf("failed to parse value to time with %q layout: %w", layout, err)
 		}
 
-		if tag == "-" {
-			continue
+		field.Set(reflect.ValueOf(v).Convert(fieldType))
+
+		return nil
+	case durationType:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			n, parseErr := strconv.ParseInt(value, 10, 64)
+			if parseErr != nil {
+				return fmt.Errorf("failed to parse value to duration: %w", err)
+			}
+
+			v = time.Duration(n)
 		}
 
-		if err := json.NewDecoder(request.Body).Decode(destination); err != nil {
-			return fmt.Errorf("failed to decode request body: %w", err)
+		field.SetInt(int64(v))
+
+		return nil
+	case urlType:
+		v, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("failed to parse value to url: %w", err)
 		}
 
-		break
+		field.Set(reflect.ValueOf(*v).Convert(fieldType))
+
+		return nil
+	case numberType:
+		if !numberPattern.MatchString(value) {
+			return fmt.Errorf("value %q is not a valid number", value)
+		}
+
+		field.SetString(value)
+
+		return nil
 	}
 
-	return nil
-}
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) {
+		if err := field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("failed to unmarshal text to %q: %w", fieldType.String(), err)
+		}
 
-func convert(field reflect.Value, fieldType reflect.Type, value string) error {
-	if value == "" {
 		return nil
 	}
 
@@ -288,7 +755,7 @@ func convert(field reflect.Value, fieldType reflect.Type, value string) error {
 	case reflect.Bool:
 		var v bool
 
-		v, err = strconv.ParseBool(value)
+		v, err = d.parseBool(value)
 		if err == nil {
 			field.SetBool(v)
 		}
@@ -321,31 +788,337 @@ func convert(field reflect.Value, fieldType reflect.Type, value string) error {
 			field.SetComplex(v)
 		}
 	case reflect.Slice:
-		element := fieldType.Elem()
+		if fieldType.Elem().Kind() == reflect.Uint8 {
+			var v []byte
 
-		if element.Kind() == reflect.Slice {
-			return fmt.Errorf("slice element kind %q is not supported", element.Kind().String())
+			v, err = decodeBase64(value)
+			if err == nil {
+				field.SetBytes(v)
+			}
+
+			break
 		}
 
-		parts := strings.Split(value, ",")
-		slice := reflect.MakeSlice(fieldType, len(parts), len(parts))
+		return d.convertSlice(field, fieldType, tag, d.sliceValues(fieldType, tag, value))
+	case reflect.Array:
+		return d.convertArray(field, fieldType, tag, d.sliceValues(fieldType, tag, value))
+	case reflect.String:
+		if err := checkOneOf(tag, value); err != nil {
+			return err
+		}
 
-		for i, part := range parts {
-			if err := convert(slice.Index(i), element, part); err != nil {
-				return fmt.Errorf("failed to convert slice element for index %d: %w", i, err)
-			}
+		if err := d.checkPattern(tag, value); err != nil {
+			return err
 		}
 
-		field.Set(slice)
-	case reflect.String:
 		field.SetString(value)
 	default:
-		return fmt.Errorf("kind %q is not supported", field.Kind().String())
+		return fmt.Errorf("%w %q", ErrUnsupportedKind, field.Kind().String())
 	}
 
 	if err != nil {
 		return fmt.Errorf("failed to parse value to %q: %w", field.Kind().String(), err)
 	}
 
+	return checkRange(field, tag, value)
+}
+
+// checkRange checks a converted int, uint or float field against the bounds
+// in its `min` and `max` tags, if any. Bounds are parsed like the field.
+func checkRange(field reflect.Value, tag reflect.StructTag, value string) error {
+	for _, bound := range []string{"min", "max"} {
+		limit, ok := tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+
+		var order int
+
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(limit, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s tag %q: %w", bound, limit, err)
+			}
+
+			order = cmp.Compare(field.Int(), n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n, err := strconv.ParseUint(limit, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s tag %q: %w", bound, limit, err)
+			}
+
+			order = cmp.Compare(field.Uint(), n)
+		case reflect.Float32, reflect.Float64:
+			n, err := strconv.ParseFloat(limit, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s tag %q: %w", bound, limit, err)
+			}
+
+			order = cmp.Compare(field.Float(), n)
+		default:
+			return nil
+		}
+
+		if bound == "min" && order < 0 {
+			return fmt.Errorf("value %s is less than minimum %s", value, limit)
+		}
+
+		if bound == "max" && order > 0 {
+			return fmt.Errorf("value %s is greater than maximum %s", value, limit)
+		}
+	}
+
+	return nil
+}
+
+// baseType returns time.Time or url.URL for types defined over them, such as
+// `type Date time.Time`, unless they implement encoding.TextUnmarshaler
+// themselves, and t otherwise. Other defined types convert through their kind.
+func baseType(t reflect.Type) reflect.Type {
+	if t.Kind() != reflect.Struct || t == timeType || t == urlType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return t
+	}
+
+	for _, base := range []reflect.Type{timeType, urlType} {
+		if t.ConvertibleTo(base) {
+			return base
+		}
+	}
+
+	return t
+}
+
+// numberPattern matches the JSON number syntax, which json.Number fields hold.
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// isScalar reports whether a value of type t, or of the type t points to, is
+// converted from a single request value as a whole rather than split into
+// elements: anything but slices and arrays, and slices and arrays that have a
+// registered converter, implement encoding.TextUnmarshaler or are []byte.
+func (d *Decoder) isScalar(t reflect.Type) bool {
+	t = indirect(t)
+
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return true
+	}
+
+	if _, ok := lookupConverter(t); ok {
+		return true
+	}
+
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// isStructSlice reports whether t, or the type t points to, is a slice or array
+// of structs, or of pointers to structs, that request values cannot be
+// converted into, such as []Item. Such fields can only be decoded from the body.
+func (d *Decoder) isStructSlice(t reflect.Type) bool {
+	t = indirect(t)
+
+	if d.isScalar(t) {
+		return false
+	}
+
+	if _, ok := lookupConverter(t.Elem()); ok {
+		return false
+	}
+
+	element := indirect(t.Elem())
+
+	if _, ok := lookupConverter(element); ok {
+		return false
+	}
+
+	return element.Kind() == reflect.Struct && element == baseType(element) && element != timeType && element != urlType && !reflect.PointerTo(element).Implements(textUnmarshalerType)
+}
+
+// parseBool parses value like strconv.ParseBool, also accepting on/off, yes/no
+// and y/n in any case when d was created with WithExtendedBoolLiterals.
+func (d *Decoder) parseBool(value string) (bool, error) {
+	if d.extendedBoolLiterals {
+		switch strings.ToLower(value) {
+		case "on", "yes", "y":
+			return true, nil
+		case "off", "no", "n":
+			return false, nil
+		}
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// checkOneOf checks value against the space-separated set of allowed values
+// in the `oneof` tag, if any.
+func checkOneOf(tag reflect.StructTag, value string) error {
+	oneOf, ok := tag.Lookup("oneof")
+	if !ok {
+		return nil
+	}
+
+	allowed := strings.Fields(oneOf)
+
+	if !slices.Contains(allowed, value) {
+		return fmt.Errorf("value %q is not one of %q", value, allowed)
+	}
+
+	return nil
+}
+
+// checkPattern checks value against the regular expression in the `pattern`
+// tag, if any.
+func (d *Decoder) checkPattern(tag reflect.StructTag, value string) error {
+	expr, ok := tag.Lookup("pattern")
+	if !ok {
+		return nil
+	}
+
+	re, err := d.pattern(expr)
+	if err != nil {
+		return err
+	}
+
+	if !re.MatchString(value) {
+		return fmt.Errorf("value %q does not match pattern %q", value, expr)
+	}
+
+	return nil
+}
+
+// decodeBase64 decodes value with the standard base64 encoding, or the URL-safe
+// one when value contains '-' or '_', with or without padding.
+func decodeBase64(value string) ([]byte, error) {
+	encoding := base64.StdEncoding
+	if strings.ContainsAny(value, "-_") {
+		encoding = base64.URLEncoding
+	}
+
+	if !strings.HasSuffix(value, "=") {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+
+	return encoding.DecodeString(value)
+}
+
+// splitValue splits a single value into slice or array elements on the
+// separator given by the `delim` tag, or a comma when the tag is absent.
+func splitValue(tag reflect.StructTag, value string) []string {
+	delim := tag.Get("delim")
+	if delim == "" {
+		delim = ","
+	}
+
+	return strings.Split(value, delim)
+}
+
+// sliceValues returns the elements a single value of a slice or array type
+// holds. Elements that are slices or arrays themselves take the whole value,
+// which they split in turn, so that repeated values such as "?m=1,2&m=3,4"
+// map onto [][]int{{1, 2}, {3, 4}}.
+func (d *Decoder) sliceValues(fieldType reflect.Type, tag reflect.StructTag, value string) []string {
+	if !d.isScalar(fieldType.Elem()) {
+		return []string{value}
+	}
+
+	return splitValue(tag, value)
+}
+
+// isList reports whether field is tagged `list:"true"`, holding the elements
+// of comma-separated list headers rather than whole header lines.
+func isList(field reflect.StructField) bool {
+	list, _ := strconv.ParseBool(field.Tag.Get("list"))
+
+	return list
+}
+
+// splitList splits header lines holding comma-separated lists, such as
+// "en;q=0.9, fr;q=0.8", into their elements without parameters or surrounding
+// whitespace, such as "en" and "fr", in the order they were sent. Empty
+// elements are dropped.
+func splitList(lines []string) []string {
+	var elements []string
+
+	for _, line := range lines {
+		for element := range strings.SplitSeq(line, ",") {
+			element, _, _ = strings.Cut(element, ";")
+
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+
+	return elements
+}
+
+// sliceElements prepares values for conversion into slice or array elements.
+// With the `trim:"true"` tag each value is trimmed of surrounding whitespace,
+// and with the `skipempty:"true"` tag empty values are dropped.
+func sliceElements(tag reflect.StructTag, values []string) []string {
+	trim, _ := strconv.ParseBool(tag.Get("trim"))
+	skipEmpty, _ := strconv.ParseBool(tag.Get("skipempty"))
+
+	if !trim && !skipEmpty {
+		return values
+	}
+
+	elements := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if trim {
+			value = strings.TrimSpace(value)
+		}
+
+		if skipEmpty && value == "" {
+			continue
+		}
+
+		elements = append(elements, value)
+	}
+
+	return elements
+}
+
+func (d *Decoder) convertSlice(field reflect.Value, fieldType reflect.Type, tag reflect.StructTag, values []string) error {
+	element := fieldType.Elem()
+	values = sliceElements(tag, values)
+
+	slice := reflect.MakeSlice(fieldType, len(values), len(values))
+
+	for i, value := range values {
+		if err := d.convert(slice.Index(i), element, tag, value); err != nil {
+			return fmt.Errorf("failed to convert slice element for index %d: %w", i, err)
+		}
+	}
+
+	field.Set(slice)
+
+	return nil
+}
+
+// convertArray converts values into the leading elements of a fixed-size
+// array, leaving the remaining elements zero.
+func (d *Decoder) convertArray(field reflect.Value, fieldType reflect.Type, tag reflect.StructTag, values []string) error {
+	element := fieldType.Elem()
+	values = sliceElements(tag, values)
+
+	if len(values) > fieldType.Len() {
+		return fmt.Errorf("got %d values for array of length %d", len(values), fieldType.Len())
+	}
+
+	array := reflect.New(fieldType).Elem()
+
+	for i, value := range values {
+		if err := d.convert(array.Index(i), element, tag, value); err != nil {
+			return fmt.Errorf("failed to convert array element for index %d: %w", i, err)
+		}
+	}
+
+	field.Set(array)
+
 	return nil
 }
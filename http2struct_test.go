@@ -0,0 +1,2148 @@
+package http2struct
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// formPart is a field or, when filename is set, a file of a multipart form.
+type formPart struct {
+	name        string
+	filename    string
+	contentType string
+	content     string
+}
+
+// newJSONRequest returns a POST request to target carrying body as JSON.
+func newJSONRequest(target, body string) *http.Request {
+	return newBodyRequest(target, "application/json", body)
+}
+
+// newMultipartRequest returns a POST request carrying parts as a multipart
+// form.
+func newMultipartRequest(t *testing.T, parts []formPart) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range parts {
+		if part.filename == "" {
+			if err := writer.WriteField(part.name, part.content); err != nil {
+				t.Fatal(err)
+			}
+
+			continue
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, part.name, part.filename))
+
+		if part.contentType != "" {
+			header.Set("Content-Type", part.contentType)
+		}
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(w, part.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return request
+}
+
+// newFormRequest returns a POST request to target carrying values as a
+// URL-encoded form.
+func newFormRequest(target string, values url.Values) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return request
+}
+
+func TestConvertKeepsBodyFields(t *testing.T) {
+	type mixed struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Page  int      `query:"page"`
+		Token string   `header:"X-Token"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		headers map[string]string
+		want    mixed
+	}{
+		{
+			name:   "body and query",
+			target: "/?page=2",
+			body:   `{"name":"ada","tags":["a","b"]}`,
+			want:   mixed{Name: "ada", Tags: []string{"a", "b"}, Page: 2},
+		},
+		{
+			name:    "body and header",
+			target:  "/",
+			body:    `{"name":"ada"}`,
+			headers: map[string]string{"X-Token": "secret"},
+			want:    mixed{Name: "ada", Token: "secret"},
+		},
+		{
+			name:    "every source",
+			target:  "/?page=3",
+			body:    `{"name":"ada","tags":["x"]}`,
+			headers: map[string]string{"X-Token": "secret"},
+			want:    mixed{Name: "ada", Tags: []string{"x"}, Page: 3, Token: "secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newJSONRequest(tt.target, tt.body)
+
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			var got mixed
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRepeatedFormFields(t *testing.T) {
+	type colors struct {
+		Colors []string `form:"colors"`
+		Sizes  []int    `form:"sizes"`
+	}
+
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		want    colors
+	}{
+		{
+			name: "urlencoded repeated",
+			request: func(*testing.T) *http.Request {
+				return newFormRequest("/", url.Values{"colors": {"red", "green"}, "sizes": {"1", "2"}})
+			},
+			want: colors{Colors: []string{"red", "green"}, Sizes: []int{1, 2}},
+		},
+		{
+			name: "urlencoded comma separated",
+			request: func(*testing.T) *http.Request {
+				return newFormRequest("/", url.Values{"colors": {"red,green"}, "sizes": {"3,4"}})
+			},
+			want: colors{Colors: []string{"red", "green"}, Sizes: []int{3, 4}},
+		},
+		{
+			name: "multipart repeated",
+			request: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, []formPart{
+					{name: "colors", content: "red"},
+					{name: "colors", content: "green"},
+					{name: "sizes", content: "5"},
+					{name: "sizes", content: "6"},
+				})
+			},
+			want: colors{Colors: []string{"red", "green"}, Sizes: []int{5, 6}},
+		},
+		{
+			name: "multipart comma separated",
+			request: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, []formPart{
+					{name: "colors", content: "red,green,blue"},
+				})
+			},
+			want: colors{Colors: []string{"red", "green", "blue"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got colors
+			if err := Convert(tt.request(t), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertURLEncodedForm(t *testing.T) {
+	type signup struct {
+		Name    string  `form:"name"`
+		Age     int     `form:"age"`
+		Score   float64 `form:"score"`
+		Agree   bool    `form:"agree"`
+		Referer string  `query:"name"`
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		values url.Values
+		want   signup
+	}{
+		{
+			name:   "typed fields",
+			target: "/",
+			values: url.Values{"name": {"ada"}, "age": {"36"}, "score": {"9.5"}, "agree": {"true"}},
+			want:   signup{Name: "ada", Age: 36, Score: 9.5, Agree: true},
+		},
+		{
+			name:   "form reads the body and query the URL",
+			target: "/?name=url",
+			values: url.Values{"name": {"body"}},
+			want:   signup{Name: "body", Referer: "url"},
+		},
+		{
+			name:   "query values do not fill form fields",
+			target: "/?age=40",
+			values: url.Values{"name": {"ada"}},
+			want:   signup{Name: "ada"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got signup
+			if err := Convert(newFormRequest(tt.target, tt.values), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertCookies(t *testing.T) {
+	type session struct {
+		ID     string   `cookie:"session_id" required:"true"`
+		Visits int      `cookie:"visits" default:"1"`
+		Admin  bool     `cookie:"admin"`
+		Roles  []string `cookie:"roles"`
+	}
+
+	tests := []struct {
+		name    string
+		cookies map[string]string
+		want    session
+		wantErr any
+	}{
+		{
+			name:    "present",
+			cookies: map[string]string{"session_id": "abc", "visits": "5", "admin": "true", "roles": "read,write"},
+			want:    session{ID: "abc", Visits: 5, Admin: true, Roles: []string{"read", "write"}},
+		},
+		{
+			name:    "absent optional",
+			cookies: map[string]string{"session_id": "abc"},
+			want:    session{ID: "abc", Visits: 1},
+		},
+		{
+			name:    "absent required",
+			cookies: map[string]string{"visits": "2"},
+			wantErr: new(*RequiredError),
+		},
+		{
+			name:    "malformed",
+			cookies: map[string]string{"session_id": "abc", "visits": "many"},
+			wantErr: new(*ConvertError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			for name, value := range tt.cookies {
+				request.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+
+			var got session
+
+			err := Convert(request, &got)
+			if tt.wantErr != nil {
+				if !errors.As(err, tt.wantErr) {
+					t.Fatalf("Convert() error = %v, want %T", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertQueryMaps(t *testing.T) {
+	type search struct {
+		Filters  map[string]string   `query:"filter"`
+		Minimums map[string]int      `query:"min"`
+		All      map[string][]string `query:"*"`
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   search
+	}{
+		{
+			name:   "bracketed namespace",
+			target: "/?filter[status]=open&filter[owner]=me",
+			want: search{
+				Filters: map[string]string{"status": "open", "owner": "me"},
+				All:     map[string][]string{"filter[status]": {"open"}, "filter[owner]": {"me"}},
+			},
+		},
+		{
+			name:   "typed values",
+			target: "/?min[price]=10&min[rating]=4",
+			want: search{
+				Minimums: map[string]int{"price": 10, "rating": 4},
+				All:      map[string][]string{"min[price]": {"10"}, "min[rating]": {"4"}},
+			},
+		},
+		{
+			name:   "catch-all",
+			target: "/?page=2&tag=a&tag=b",
+			want: search{
+				All: map[string][]string{"page": {"2"}, "tag": {"a", "b"}},
+			},
+		},
+		{
+			name:   "no parameters",
+			target: "/",
+			want:   search{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got search
+			if err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertFormMaps(t *testing.T) {
+	type product struct {
+		Attributes map[string][]string `form:"attrs"`
+		Prices     map[string]int      `form:"price"`
+		Name       string              `form:"name"`
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    product
+	}{
+		{
+			name: "repeated keys",
+			request: func() *http.Request {
+				return newBodyRequest("/", "application/x-www-form-urlencoded", "attrs[color]=red&attrs[color]=blue&attrs[size]=M&name=shirt")
+			},
+			want: product{
+				Attributes: map[string][]string{"color": {"red", "blue"}, "size": {"M"}},
+				Name:       "shirt",
+			},
+		},
+		{
+			name: "multipart",
+			request: func() *http.Request {
+				return newMultipartRequest(t, []formPart{
+					{name: "attrs[color]", content: "red"},
+					{name: "attrs[color]", content: "green"},
+					{name: "price[eur]", content: "10"},
+				})
+			},
+			want: product{
+				Attributes: map[string][]string{"color": {"red", "green"}},
+				Prices:     map[string]int{"eur": 10},
+			},
+		},
+		{
+			name: "unbracketed keys ignored",
+			request: func() *http.Request {
+				return newBodyRequest("/", "application/x-www-form-urlencoded", "attrs=red&attrs[color=blue&name=shirt")
+			},
+			want: product{Name: "shirt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got product
+			if err := Convert(tt.request(), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertArrays(t *testing.T) {
+	type point struct {
+		Coords [3]int `query:"coords"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    [3]int
+		wantErr bool
+	}{
+		{name: "exact", target: "/?coords=1,2,3", want: [3]int{1, 2, 3}},
+		{name: "exact repeated", target: "/?coords=4&coords=5&coords=6", want: [3]int{4, 5, 6}},
+		{name: "under-filled", target: "/?coords=7,8", want: [3]int{7, 8, 0}},
+		{name: "over-filled", target: "/?coords=1,2,3,4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got point
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got.Coords != tt.want {
+				t.Errorf("Coords = %v, want %v", got.Coords, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertValues(t *testing.T) {
+	type list struct {
+		Page   int      `query:"page"`
+		Active bool     `query:"active"`
+		IDs    []int    `query:"ids"`
+		Tags   []string `query:"tags"`
+		Token  string   `header:"X-Token"`
+	}
+
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    list
+		wantErr bool
+	}{
+		{
+			name:   "scalars and slices",
+			values: url.Values{"page": {"2"}, "active": {"true"}, "ids": {"1,2,3"}, "tags": {"a", "b"}},
+			want:   list{Page: 2, Active: true, IDs: []int{1, 2, 3}, Tags: []string{"a", "b"}},
+		},
+		{
+			name:   "other sources ignored",
+			values: url.Values{"X-Token": {"secret"}},
+			want:   list{},
+		},
+		{
+			name:    "invalid int",
+			values:  url.Values{"page": {"two"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got list
+
+			err := ConvertValues(tt.values, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConvertValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConvertValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertSliceElementTrimming(t *testing.T) {
+	type tagged struct {
+		Raw     []string `query:"tags"`
+		Trimmed []string `query:"tags" trim:"true"`
+		Skipped []string `query:"tags" trim:"true" skipempty:"true"`
+		IDs     []int    `query:"ids" trim:"true"`
+		Numbers []int    `query:"numbers"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    tagged
+		wantErr bool
+	}{
+		{
+			name:   "untrimmed and trimmed",
+			target: "/?tags=a,%20b%20,c",
+			want: tagged{
+				Raw:     []string{"a", " b ", "c"},
+				Trimmed: []string{"a", "b", "c"},
+				Skipped: []string{"a", "b", "c"},
+			},
+		},
+		{
+			name:   "empty elements",
+			target: "/?tags=a,,%20,b",
+			want: tagged{
+				Raw:     []string{"a", "", " ", "b"},
+				Trimmed: []string{"a", "", "", "b"},
+				Skipped: []string{"a", "b"},
+			},
+		},
+		{
+			name:   "trimmed numbers",
+			target: "/?ids=1,%202,3%20",
+			want:   tagged{IDs: []int{1, 2, 3}},
+		},
+		{
+			name:    "untrimmed numbers",
+			target:  "/?numbers=1,%202",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got tagged
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertSliceDelimiter(t *testing.T) {
+	type filters struct {
+		Tags   []string `query:"tags" delim:"|"`
+		IDs    []int    `query:"ids" delim:";"`
+		Scopes []string `header:"X-Scopes" delim:";" trim:"true"`
+		Names  []string `query:"names"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		scopes  string
+		want    filters
+		wantErr bool
+	}{
+		{
+			name:   "pipe",
+			target: "/?tags=a|b,c|d",
+			want:   filters{Tags: []string{"a", "b,c", "d"}},
+		},
+		{
+			// net/url drops query pairs holding a raw semicolon, so it is escaped.
+			name:   "semicolon",
+			target: "/?ids=1%3B2%3B3",
+			want:   filters{IDs: []int{1, 2, 3}},
+		},
+		{
+			name:   "semicolon header",
+			target: "/",
+			scopes: "read; write",
+			want:   filters{Scopes: []string{"read", "write"}},
+		},
+		{
+			name:   "comma by default",
+			target: "/?names=a,b|c",
+			want:   filters{Names: []string{"a", "b|c"}},
+		},
+		{
+			name:    "comma under semicolon",
+			target:  "/?ids=1,2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.scopes != "" {
+				request.Header.Set("X-Scopes", tt.scopes)
+			}
+
+			var got filters
+
+			err := Convert(request, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertURLFields(t *testing.T) {
+	type redirect struct {
+		Next     url.URL    `query:"next"`
+		Callback *url.URL   `header:"X-Callback"`
+		Mirrors  []*url.URL `query:"mirror"`
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		callback string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "absolute",
+			target:   "/?next=" + url.QueryEscape("https://example.com/a?b=c"),
+			callback: "https://hooks.example.com/cb",
+			want:     []string{"https://example.com/a?b=c", "https://hooks.example.com/cb"},
+		},
+		{
+			name:   "relative",
+			target: "/?next=" + url.QueryEscape("/dashboard?tab=2"),
+			want:   []string{"/dashboard?tab=2", ""},
+		},
+		{
+			name:   "slice",
+			target: "/?next=a&mirror=https://a.example.com&mirror=/b",
+			want:   []string{"a", "", "https://a.example.com", "/b"},
+		},
+		{
+			name:    "invalid",
+			target:  "/?next=" + url.QueryEscape("http://[::1"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.callback != "" {
+				request.Header.Set("X-Callback", tt.callback)
+			}
+
+			var got redirect
+
+			err := Convert(request, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			urls := []string{got.Next.String(), ""}
+			if got.Callback != nil {
+				urls[1] = got.Callback.String()
+			}
+
+			for _, mirror := range got.Mirrors {
+				urls = append(urls, mirror.String())
+			}
+
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("Convert() = %q, want %q", urls, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertUnixTimes(t *testing.T) {
+	type window struct {
+		Since time.Time  `query:"since" timeformat:"unix"`
+		Until *time.Time `query:"until" timeformat:"unixmilli"`
+	}
+
+	until := time.UnixMilli(1714555800123).UTC()
+
+	tests := []struct {
+		name    string
+		target  string
+		want    window
+		wantErr bool
+	}{
+		{
+			name:   "seconds and milliseconds",
+			target: "/?since=1714555800&until=1714555800123",
+			want:   window{Since: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), Until: &until},
+		},
+		{
+			name:   "negative seconds",
+			target: "/?since=-86400",
+			want:   window{Since: time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:    "fractional seconds",
+			target:  "/?since=1714555800.5",
+			wantErr: true,
+		},
+		{
+			name:    "formatted time",
+			target:  "/?until=2024-05-01T09:30:00Z",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got window
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Named types defined over built-in kinds, converted through their kind.
+type (
+	status      string
+	priority    int
+	temperature float64
+	date        time.Time
+)
+
+func TestConvertNamedTypes(t *testing.T) {
+	type ticket struct {
+		Status   status        `query:"status" oneof:"open closed"`
+		Priority priority      `header:"X-Priority"`
+		Temp     *temperature  `query:"temp"`
+		Labels   []status      `query:"labels"`
+		Limits   []temperature `query:"limits"`
+		Due      date          `query:"due" timeformat:"2006-01-02"`
+	}
+
+	temp := temperature(36.6)
+
+	tests := []struct {
+		name     string
+		target   string
+		priority string
+		want     ticket
+		wantErr  bool
+	}{
+		{
+			name:     "every kind",
+			target:   "/?status=open&temp=36.6&labels=a,b&limits=1.5,2&due=2024-05-01",
+			priority: "3",
+			want: ticket{
+				Status:   "open",
+				Priority: 3,
+				Temp:     &temp,
+				Labels:   []status{"a", "b"},
+				Limits:   []temperature{1.5, 2},
+				Due:      date(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name:     "invalid int",
+			target:   "/",
+			priority: "high",
+			wantErr:  true,
+		},
+		{
+			name:    "invalid float",
+			target:  "/?temp=warm",
+			wantErr: true,
+		},
+		{
+			name:    "oneof on string",
+			target:  "/?status=pending",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.priority != "" {
+				request.Header.Set("X-Priority", tt.priority)
+			}
+
+			var got ticket
+
+			err := Convert(request, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertFlags(t *testing.T) {
+	type export struct {
+		Verbose bool `query:"verbose" flag:"true"`
+		Debug   bool `query:"debug"`
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   export
+	}{
+		{name: "present without value", target: "/?verbose", want: export{Verbose: true}},
+		{name: "present empty", target: "/?verbose=", want: export{Verbose: true}},
+		{name: "present true", target: "/?verbose=true", want: export{Verbose: true}},
+		{name: "present false", target: "/?verbose=false", want: export{}},
+		{name: "absent", target: "/", want: export{}},
+		{name: "untagged flag stays false", target: "/?debug", want: export{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got export
+			if err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertBase64Bytes(t *testing.T) {
+	type token struct {
+		Token []byte `header:"X-Token"`
+	}
+
+	content := []byte{0xfb, 0xff, 0x01, 'h', 'i'}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "standard", value: base64.StdEncoding.EncodeToString(content), want: content},
+		{name: "URL-safe", value: base64.URLEncoding.EncodeToString(content), want: content},
+		{name: "invalid", value: "not base64!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("X-Token", tt.value)
+
+			var got token
+
+			err := Convert(request, &got)
+			if tt.wantErr {
+				var convErr *ConvertError
+				if !errors.As(err, &convErr) {
+					t.Fatalf("Convert() error = %v, want a *ConvertError", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !bytes.Equal(got.Token, tt.want) {
+				t.Errorf("Token = %v, want %v", got.Token, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertPathSegments(t *testing.T) {
+	type file struct {
+		Segments []string `path:"path"`
+		Joined   string   `path:"path"`
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   file
+	}{
+		{name: "nested", target: "/files/a/b/c", want: file{Segments: []string{"a", "b", "c"}, Joined: "a/b/c"}},
+		{name: "single", target: "/files/a", want: file{Segments: []string{"a"}, Joined: "a"}},
+		{name: "trailing slash", target: "/files/a/b/", want: file{Segments: []string{"a", "b", ""}, Joined: "a/b/"}},
+		{name: "empty", target: "/files/", want: file{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got file
+				err error
+			)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/files/{path...}", func(_ http.ResponseWriter, r *http.Request) {
+				err = Convert(r, &got)
+			})
+
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("leading slash", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.SetPathValue("path", "/a/b")
+
+		var got file
+		if err := Convert(request, &got); err != nil {
+			t.Fatalf("Convert() error = %v", err)
+		}
+
+		if want := []string{"a", "b"}; !reflect.DeepEqual(got.Segments, want) {
+			t.Errorf("Segments = %q, want %q", got.Segments, want)
+		}
+	})
+}
+
+func TestConvertRangeTags(t *testing.T) {
+	type list struct {
+		Page  int     `query:"page" min:"1"`
+		Size  uint    `query:"size" min:"1" max:"100"`
+		Ratio float64 `query:"ratio" min:"0" max:"1"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    list
+		wantErr string
+	}{
+		{name: "in range", target: "/?page=3&size=100&ratio=0.5", want: list{Page: 3, Size: 100, Ratio: 0.5}},
+		{name: "int under min", target: "/?page=0", wantErr: `value 0 is less than minimum 1`},
+		{name: "uint over max", target: "/?size=101", wantErr: `value 101 is greater than maximum 100`},
+		{name: "float over max", target: "/?ratio=1.5", wantErr: `value 1.5 is greater than maximum 1`},
+		{name: "float under min", target: "/?ratio=-0.1", wantErr: `value -0.1 is less than minimum 0`},
+		{name: "absent", target: "/", want: list{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got list
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if tt.wantErr != "" {
+				var convErr *ConvertError
+				if !errors.As(err, &convErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Convert() error = %v, want a *ConvertError containing %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertOneOfTag(t *testing.T) {
+	type post struct {
+		Status string   `query:"status" oneof:"draft published archived"`
+		Tags   []string `query:"tag" oneof:"go web api"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    post
+		wantErr bool
+	}{
+		{name: "valid", target: "/?status=published&tag=go&tag=api", want: post{Status: "published", Tags: []string{"go", "api"}}},
+		{name: "invalid single value", target: "/?status=deleted", wantErr: true},
+		{name: "case sensitive", target: "/?status=Draft", wantErr: true},
+		{name: "invalid slice element", target: "/?tag=go,rust", wantErr: true},
+		{name: "absent", target: "/", want: post{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got post
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertPatternTag(t *testing.T) {
+	type product struct {
+		Slug string   `query:"slug" pattern:"^[a-z0-9-]+$"`
+		SKUs []string `query:"sku" pattern:"^[A-Z]{3}-[0-9]{4}$"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    product
+		wantErr bool
+	}{
+		{name: "matching", target: "/?slug=blue-shirt-2&sku=ABC-1234,XYZ-0001", want: product{Slug: "blue-shirt-2", SKUs: []string{"ABC-1234", "XYZ-0001"}}},
+		{name: "non-matching string", target: "/?slug=Blue_Shirt", wantErr: true},
+		{name: "non-matching element", target: "/?sku=ABC-1234&sku=abc-1234", wantErr: true},
+		{name: "absent", target: "/", want: product{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got product
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertPatternCompiledOnce(t *testing.T) {
+	type product struct {
+		Slug string `query:"slug" pattern:"^[a-z]+$"`
+	}
+
+	decoder := NewDecoder()
+
+	var wg sync.WaitGroup
+
+	for range 50 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var got product
+			if err := decoder.Decode(httptest.NewRequest(http.MethodGet, "/?slug=shirt", nil), &got); err != nil {
+				t.Errorf("Decode() error = %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	var count int
+
+	decoder.patterns.Range(func(any, any) bool {
+		count++
+
+		return true
+	})
+
+	if count != 1 {
+		t.Errorf("cached patterns = %d, want 1", count)
+	}
+
+	first, _ := decoder.pattern("^[a-z]+$")
+	second, _ := decoder.pattern("^[a-z]+$")
+
+	if first != second {
+		t.Error("pattern() compiled the expression again")
+	}
+}
+
+func TestConvertIndexedQuery(t *testing.T) {
+	type list struct {
+		Items []string `query:"items"`
+		IDs   []int    `query:"ids"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    list
+		wantErr bool
+	}{
+		{name: "ordered", target: "/?items[0]=a&items[1]=b", want: list{Items: []string{"a", "b"}}},
+		{name: "out of order", target: "/?items[2]=c&items[0]=a&items[1]=b", want: list{Items: []string{"a", "b", "c"}}},
+		{name: "sparse", target: "/?items[2]=c", want: list{Items: []string{"", "", "c"}}},
+		{name: "sparse numbers", target: "/?ids[3]=4&ids[1]=2", want: list{IDs: []int{0, 2, 0, 4}}},
+		{name: "unindexed key wins", target: "/?items=x&items[0]=a", want: list{Items: []string{"x"}}},
+		{name: "index too large", target: "/?items[1001]=a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got list
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// decimal mimics third-party decimal types such as shopspring/decimal, a
+// struct implementing encoding.TextUnmarshaler.
+type decimal struct {
+	units int64
+	scale int32
+}
+
+func (d *decimal) UnmarshalText(text []byte) error {
+	whole, fraction, _ := strings.Cut(string(text), ".")
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return fmt.Errorf("can't convert %s to decimal", text)
+	}
+
+	*d = decimal{units: units, scale: int32(len(fraction))}
+
+	return nil
+}
+
+func TestConvertTextUnmarshalerStruct(t *testing.T) {
+	type price struct {
+		Price    decimal    `query:"price"`
+		Discount *decimal   `query:"discount"`
+		Limits   []decimal  `query:"limits"`
+		Range    [2]decimal `query:"range"`
+		Fee      decimal    `query:"fee" default:"0.99"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    price
+		wantErr bool
+	}{
+		{
+			name:   "scalar",
+			target: "/?price=19.99",
+			want:   price{Price: decimal{units: 1999, scale: 2}, Fee: decimal{units: 99, scale: 2}},
+		},
+		{
+			name:   "pointer",
+			target: "/?discount=0.125",
+			want:   price{Discount: &decimal{units: 125, scale: 3}, Fee: decimal{units: 99, scale: 2}},
+		},
+		{
+			name:   "slice and array elements",
+			target: "/?limits=1.5,20&range=1,2.50",
+			want: price{
+				Limits: []decimal{{units: 15, scale: 1}, {units: 20}},
+				Range:  [2]decimal{{units: 1}, {units: 250, scale: 2}},
+				Fee:    decimal{units: 99, scale: 2},
+			},
+		},
+		{
+			name:    "invalid",
+			target:  "/?price=abc",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got price
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// CyclicA and CyclicB embed each other, which must not send plan building
+// into endless recursion. They are exported so that their embedded pointers
+// can be allocated.
+type CyclicA struct {
+	*CyclicB
+	Name string `query:"name"`
+}
+
+type CyclicB struct {
+	*CyclicA
+	Page int `query:"page"`
+}
+
+func TestConvertEmbeddedStructs(t *testing.T) {
+	type Pagination struct {
+		Page int `query:"page"`
+		Size int `query:"size"`
+	}
+
+	type Body struct {
+		Title string `json:"title"`
+	}
+
+	type list struct {
+		Pagination
+		Sort string `query:"sort"`
+	}
+
+	type pointerList struct {
+		*Pagination
+		Sort string `query:"sort"`
+	}
+
+	type withBody struct {
+		Body
+		Pagination
+	}
+
+	tests := []struct {
+		name        string
+		request     func() *http.Request
+		destination any
+		want        any
+	}{
+		{
+			name:        "promoted fields",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=2&size=10&sort=name", nil) },
+			destination: &list{},
+			want:        &list{Pagination: Pagination{Page: 2, Size: 10}, Sort: "name"},
+		},
+		{
+			name:        "embedded pointer allocated",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=3", nil) },
+			destination: &pointerList{},
+			want:        &pointerList{Pagination: &Pagination{Page: 3}},
+		},
+		{
+			name:        "embedded pointer allocated for zero value",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=0", nil) },
+			destination: &pointerList{},
+			want:        &pointerList{Pagination: &Pagination{}},
+		},
+		{
+			name:        "embedded pointer left nil",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?sort=id", nil) },
+			destination: &pointerList{},
+			want:        &pointerList{Sort: "id"},
+		},
+		{
+			name:        "embedded json fields",
+			request:     func() *http.Request { return newJSONRequest("/?page=4", `{"title":"go"}`) },
+			destination: &withBody{},
+			want:        &withBody{Body: Body{Title: "go"}, Pagination: Pagination{Page: 4}},
+		},
+		{
+			name:        "mutually embedded pointers",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?name=ada&page=5", nil) },
+			destination: &CyclicA{},
+			want:        &CyclicA{CyclicB: &CyclicB{Page: 5}, Name: "ada"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Convert(tt.request(), tt.destination); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(tt.destination, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", tt.destination, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	type invalid struct {
+		Page   int  `query:"page"`
+		Size   int  `form:"size"`
+		Active bool `header:"X-Active"`
+		ID     int  `path:"id"`
+	}
+
+	type missing struct {
+		Page   int    `query:"page" required:"true"`
+		Token  string `header:"X-Token" required:"true"`
+		ID     int    `path:"id" required:"true"`
+		Tenant string `source:"header:X-Tenant,query:tenant" required:"true"`
+		Avatar *File  `file:"avatar" required:"true"`
+	}
+
+	tests := []struct {
+		name        string
+		request     func() *http.Request
+		destination any
+		want        error
+	}{
+		{
+			name:        "query",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=x", nil) },
+			destination: &invalid{},
+			want:        &ConvertError{Field: "Page", Tag: "page", Source: "query"},
+		},
+		{
+			name:        "form",
+			request:     func() *http.Request { return newFormRequest("/", url.Values{"size": {"x"}}) },
+			destination: &invalid{},
+			want:        &ConvertError{Field: "Size", Tag: "size", Source: "form"},
+		},
+		{
+			name: "header",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.Header.Set("X-Active", "maybe")
+
+				return request
+			},
+			destination: &invalid{},
+			want:        &ConvertError{Field: "Active", Tag: "X-Active", Source: "header"},
+		},
+		{
+			name: "path",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.SetPathValue("id", "x")
+
+				return request
+			},
+			destination: &invalid{},
+			want:        &ConvertError{Field: "ID", Tag: "id", Source: "path"},
+		},
+		{
+			name:        "required query",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/", nil) },
+			destination: &missing{},
+			want:        &RequiredError{Field: "Page", Source: "query", Name: "page"},
+		},
+		{
+			name:        "required header",
+			request:     func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=1", nil) },
+			destination: &missing{},
+			want:        &RequiredError{Field: "Token", Source: "header", Name: "X-Token"},
+		},
+		{
+			name: "required path",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodGet, "/?page=1", nil)
+				request.Header.Set("X-Token", "t")
+
+				return request
+			},
+			destination: &missing{},
+			want:        &RequiredError{Field: "ID", Source: "path", Name: "id"},
+		},
+		{
+			name: "required source",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodGet, "/?page=1", nil)
+				request.Header.Set("X-Token", "t")
+				request.SetPathValue("id", "1")
+
+				return request
+			},
+			destination: &missing{},
+			want:        &RequiredError{Field: "Tenant", Source: "source", Name: "header:X-Tenant,query:tenant"},
+		},
+		{
+			name: "required file",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodGet, "/?page=1&tenant=acme", nil)
+				request.Header.Set("X-Token", "t")
+				request.SetPathValue("id", "1")
+
+				return request
+			},
+			destination: &missing{},
+			want:        &RequiredError{Field: "Avatar", Source: "file", Name: "avatar"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Convert(tt.request(), tt.destination)
+
+			switch want := tt.want.(type) {
+			case *ConvertError:
+				got, ok := err.(*ConvertError)
+				if !ok {
+					t.Fatalf("Convert() error = %#v, want a *ConvertError", err)
+				}
+
+				if got.Field != want.Field || got.Tag != want.Tag || got.Source != want.Source || got.Err == nil {
+					t.Errorf("Convert() error = %+v, want %+v", got, want)
+				}
+
+				if errors.Unwrap(got) != got.Err {
+					t.Error("Unwrap() does not return Err")
+				}
+			case *RequiredError:
+				got, ok := err.(*RequiredError)
+				if !ok {
+					t.Fatalf("Convert() error = %#v, want an unwrapped *RequiredError", err)
+				}
+
+				if *got != *want {
+					t.Errorf("Convert() error = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertJSONNumber(t *testing.T) {
+	type sequence struct {
+		Seq json.Number `query:"seq"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    json.Number
+		wantErr bool
+	}{
+		{name: "integer", target: "/?seq=9007199254740993", want: "9007199254740993"},
+		{name: "fraction", target: "/?seq=0.1000000000000000055511", want: "0.1000000000000000055511"},
+		{name: "exponent", target: "/?seq=-1.5e10", want: "-1.5e10"},
+		{name: "not a number", target: "/?seq=12abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got sequence
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got.Seq != tt.want {
+				t.Errorf("Seq = %q, want %q", got.Seq, tt.want)
+			}
+		})
+	}
+}
+
+// signature decodes itself from the request, as a RequestUnmarshaler field.
+type signature struct {
+	KeyID string
+	Valid bool
+}
+
+func (s *signature) UnmarshalRequest(r *http.Request) error {
+	keyID, sig, ok := strings.Cut(r.Header.Get("Signature"), ":")
+	if !ok {
+		return errors.New("malformed signature")
+	}
+
+	*s = signature{KeyID: keyID, Valid: sig == "valid"}
+
+	return nil
+}
+
+// selfDecoded decodes itself from the request, as a RequestUnmarshaler
+// destination whose tags are ignored.
+type selfDecoded struct {
+	Path  string `query:"ignored"`
+	Agent string
+}
+
+func (s *selfDecoded) UnmarshalRequest(r *http.Request) error {
+	s.Path = r.URL.Path
+	s.Agent = r.UserAgent()
+
+	return nil
+}
+
+func TestConvertRequestUnmarshaler(t *testing.T) {
+	type webhook struct {
+		Event     string     `header:"X-Event"`
+		Signature *signature `header:"Signature"`
+	}
+
+	tests := []struct {
+		name        string
+		header      string
+		destination any
+		want        any
+		wantErr     bool
+	}{
+		{
+			name:        "destination",
+			destination: &selfDecoded{},
+			want:        &selfDecoded{Path: "/hooks", Agent: "tester"},
+		},
+		{
+			name:        "field",
+			header:      "key-1:valid",
+			destination: &webhook{},
+			want:        &webhook{Event: "push", Signature: &signature{KeyID: "key-1", Valid: true}},
+		},
+		{
+			name:        "field error",
+			header:      "malformed",
+			destination: &webhook{},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/hooks?ignored=x", nil)
+			request.Header.Set("User-Agent", "tester")
+			request.Header.Set("X-Event", "push")
+			request.Header.Set("Signature", tt.header)
+
+			err := Convert(request, tt.destination)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(tt.destination, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", tt.destination, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertMessageTag(t *testing.T) {
+	type signup struct {
+		Email string `form:"email" required:"true" pattern:"^[^@]+@[^@]+$" msg:"Please provide a valid email"`
+		Age   int    `form:"age" min:"18" msg:"You must be at least 18"`
+		Plan  string `form:"plan" oneof:"free pro"`
+	}
+
+	tests := []struct {
+		name      string
+		values    url.Values
+		wantField string
+		wantMsg   string
+		wantErr   any
+	}{
+		{name: "required", values: url.Values{}, wantField: "Email", wantMsg: "Please provide a valid email", wantErr: new(*RequiredError)},
+		{name: "pattern", values: url.Values{"email": {"nope"}}, wantField: "Email", wantMsg: "Please provide a valid email", wantErr: new(*ConvertError)},
+		{name: "parse error", values: url.Values{"email": {"a@b"}, "age": {"old"}}, wantField: "Age", wantMsg: "You must be at least 18", wantErr: new(*ConvertError)},
+		{name: "constraint", values: url.Values{"email": {"a@b"}, "age": {"16"}}, wantField: "Age", wantMsg: "You must be at least 18", wantErr: new(*ConvertError)},
+		{name: "no msg tag", values: url.Values{"email": {"a@b"}, "plan": {"gold"}}, wantErr: new(*ConvertError)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got signup
+
+			err := Convert(newFormRequest("/", tt.values), &got)
+			if !errors.As(err, tt.wantErr) {
+				t.Fatalf("Convert() error = %v, want it to wrap %T", err, tt.wantErr)
+			}
+
+			var msgErr *MessageError
+			if ok := errors.As(err, &msgErr); ok != (tt.wantMsg != "") {
+				t.Fatalf("Convert() error = %v, MessageError found %v", err, ok)
+			}
+
+			if tt.wantMsg == "" {
+				return
+			}
+
+			if msgErr.Field != tt.wantField || msgErr.Message != tt.wantMsg {
+				t.Errorf("MessageError = %q %q, want %q %q", msgErr.Field, msgErr.Message, tt.wantField, tt.wantMsg)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Error() = %q, want it to contain %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestConvertBodySliceWithQuery(t *testing.T) {
+	type item struct {
+		SKU string `json:"sku"`
+		Qty int    `json:"qty"`
+	}
+
+	type order struct {
+		Items []item `json:"items"`
+		Page  int    `query:"page"`
+	}
+
+	type tagged struct {
+		Items []item `json:"items" query:"items"`
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		destination any
+		want        any
+		wantErr     error
+	}{
+		{
+			name:        "json items and query page",
+			target:      "/?page=2",
+			body:        `{"items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]}`,
+			destination: &order{},
+			want:        &order{Items: []item{{SKU: "a", Qty: 1}, {SKU: "b", Qty: 2}}, Page: 2},
+		},
+		{
+			name:        "query does not clobber items",
+			target:      "/?page=3&items=x",
+			body:        `{"items":[{"sku":"a","qty":1}]}`,
+			destination: &order{},
+			want:        &order{Items: []item{{SKU: "a", Qty: 1}}, Page: 3},
+		},
+		{
+			name:        "query tag on struct slice",
+			target:      "/?items=x",
+			body:        `{"items":[{"sku":"a","qty":1}]}`,
+			destination: &tagged{},
+			want:        &tagged{Items: []item{{SKU: "a", Qty: 1}}},
+			wantErr:     ErrUnsupportedKind,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Convert(newJSONRequest(tt.target, tt.body), tt.destination)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Convert() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(tt.destination, tt.want) {
+				t.Errorf("Convert() = %+v, want %+v", tt.destination, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertMetaTag(t *testing.T) {
+	type info struct {
+		Method     string `meta:"method"`
+		Host       string `meta:"host"`
+		RemoteAddr string `meta:"remoteaddr"`
+		Path       string `meta:"path"`
+		RawQuery   string `meta:"rawquery"`
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "http://api.example.com/users/42?page=2&sort=name", nil)
+	request.RemoteAddr = "203.0.113.7:51234"
+
+	var got info
+	if err := Convert(request, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	want := info{
+		Method:     http.MethodPut,
+		Host:       "api.example.com",
+		RemoteAddr: "203.0.113.7:51234",
+		Path:       "/users/42",
+		RawQuery:   "page=2&sort=name",
+	}
+
+	if got != want {
+		t.Errorf("Convert() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConvertMetaTagUnknownKey(t *testing.T) {
+	type info struct {
+		Scheme string `meta:"scheme"`
+	}
+
+	if err := Convert(httptest.NewRequest(http.MethodGet, "/", nil), &info{}); err == nil {
+		t.Error("Convert() error = nil, want an error for the unknown key")
+	}
+}
+
+func TestConvertBasicAuth(t *testing.T) {
+	type login struct {
+		Username string `auth:"username" required:"true"`
+		Password string `auth:"password"`
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          login
+		wantErr       bool
+	}{
+		{
+			name:          "valid credentials",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("ada:s3cr:et")),
+			want:          login{Username: "ada", Password: "s3cr:et"},
+		},
+		{
+			name:    "missing credentials",
+			wantErr: true,
+		},
+		{
+			name:          "bearer token",
+			authorization: "Bearer abc",
+			wantErr:       true,
+		},
+		{
+			name:          "malformed credentials",
+			authorization: "Basic not-base64",
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+
+			var got login
+
+			err := Convert(request, &got)
+
+			var requiredErr *RequiredError
+			if tt.wantErr != errors.As(err, &requiredErr) {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertBearerToken(t *testing.T) {
+	type authenticated struct {
+		Token string `auth:"bearer"`
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{name: "present", authorization: "Bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "scheme case", authorization: "bearer abc", want: "abc"},
+		{name: "extra spaces", authorization: "Bearer  abc ", want: "abc"},
+		{name: "wrong scheme", authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("ada:secret")), want: ""},
+		{name: "no token", authorization: "Bearer", want: ""},
+		{name: "absent", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+
+			var got authenticated
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got.Token != tt.want {
+				t.Errorf("Token = %q, want %q", got.Token, tt.want)
+			}
+		})
+	}
+}
+
+// errInvalidRange is returned by dateRange.Validate.
+var errInvalidRange = errors.New("from must not be after to")
+
+// dateRange validates that its mapped bounds are in order.
+type dateRange struct {
+	From int `query:"from"`
+	To   int `query:"to"`
+}
+
+func (r *dateRange) Validate() error {
+	if r.From > r.To {
+		return errInvalidRange
+	}
+
+	return nil
+}
+
+func TestConvertValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    dateRange
+		wantErr error
+	}{
+		{name: "valid", target: "/?from=1&to=5", want: dateRange{From: 1, To: 5}},
+		{name: "rejected after mapping", target: "/?from=9&to=5", wantErr: errInvalidRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got dateRange
+
+			err := Convert(httptest.NewRequest(http.MethodGet, tt.target, nil), &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Convert() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertValidatorSkippedOnMappingError(t *testing.T) {
+	var got dateRange
+
+	err := Convert(httptest.NewRequest(http.MethodGet, "/?from=9&to=x", nil), &got)
+
+	var convertErr *ConvertError
+	if !errors.As(err, &convertErr) || errors.Is(err, errInvalidRange) {
+		t.Errorf("Convert() error = %v, want a *ConvertError only", err)
+	}
+}
+
+func TestConvertSourceTag(t *testing.T) {
+	type tenantRequest struct {
+		Tenant string `source:"header:X-Tenant,query:tenant,path:tenant" default:"public"`
+		Port   int    `source:"query:port,cookie:port"`
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		header  string
+		path    string
+		cookie  string
+		want    tenantRequest
+		wantErr bool
+	}{
+		{name: "first source", target: "/?tenant=query", header: "header", path: "path", want: tenantRequest{Tenant: "header"}},
+		{name: "second source", target: "/?tenant=query", path: "path", want: tenantRequest{Tenant: "query"}},
+		{name: "third source", target: "/?tenant=", path: "path", want: tenantRequest{Tenant: "path"}},
+		{name: "no source", target: "/", want: tenantRequest{Tenant: "public"}},
+		{name: "converted", target: "/", cookie: "8080", want: tenantRequest{Tenant: "public", Port: 8080}},
+		{name: "invalid value", target: "/?port=http", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			request.SetPathValue("tenant", tt.path)
+
+			if tt.header != "" {
+				request.Header.Set("X-Tenant", tt.header)
+			}
+
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "port", Value: tt.cookie})
+			}
+
+			var got tenantRequest
+
+			err := Convert(request, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Convert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertAnyFields(t *testing.T) {
+	type event struct {
+		Type  string `json:"type"`
+		Extra any    `json:"extra"`
+		Actor any    `context:"actor"`
+	}
+
+	request := newJSONRequest("/", `{"type":"push","extra":{"ref":"main","commits":[1,2]}}`)
+	request = request.WithContext(context.WithValue(request.Context(), ContextKey("actor"), &user{ID: 1, Name: "ada"}))
+
+	var got event
+	if err := Convert(request, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	want := event{
+		Type:  "push",
+		Extra: map[string]any{"ref": "main", "commits": []any{float64(1), float64(2)}},
+		Actor: &user{ID: 1, Name: "ada"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Convert() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConvertAnyFieldsFromOtherSources(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination any
+	}{
+		{name: "query", destination: &struct {
+			Value any `query:"v"`
+		}{}},
+		{name: "header", destination: &struct {
+			Value any `header:"X-V"`
+		}{}},
+		{name: "form", destination: &struct {
+			Value any `form:"v"`
+		}{}},
+		{name: "path", destination: &struct {
+			Value any `path:"v"`
+		}{}},
+		{name: "cookie", destination: &struct {
+			Value any `cookie:"v"`
+		}{}},
+		{name: "meta", destination: &struct {
+			Value any `meta:"method"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newBodyRequest("/?v=1", "application/x-www-form-urlencoded", "v=1")
+			request.Header.Set("X-V", "1")
+			request.SetPathValue("v", "1")
+			request.AddCookie(&http.Cookie{Name: "v", Value: "1"})
+
+			if err := Convert(request, tt.destination); !errors.Is(err, ErrUnsupportedKind) {
+				t.Errorf("Convert() error = %v, want %v", err, ErrUnsupportedKind)
+			}
+		})
+	}
+}
+
+func TestMustConvert(t *testing.T) {
+	type list struct {
+		Page int `query:"page"`
+	}
+
+	var got list
+	MustConvert(httptest.NewRequest(http.MethodGet, "/?page=2", nil), &got)
+
+	if got.Page != 2 {
+		t.Errorf("Page = %d, want 2", got.Page)
+	}
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, ErrNotPointer) {
+			t.Errorf("MustConvert() panicked with %v, want an error wrapping %v", err, ErrNotPointer)
+		}
+	}()
+
+	MustConvert(httptest.NewRequest(http.MethodGet, "/?page=2", nil), got)
+}
+
+func TestMustDecode(t *testing.T) {
+	type list struct {
+		Page int `query:"page"`
+	}
+
+	if got := MustDecode[list](httptest.NewRequest(http.MethodGet, "/?page=2", nil)); got.Page != 2 {
+		t.Errorf("Page = %d, want 2", got.Page)
+	}
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, ErrNotStruct) {
+			t.Errorf("MustDecode() panicked with %v, want an error wrapping %v", err, ErrNotStruct)
+		}
+	}()
+
+	MustDecode[int](httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestConvertListHeaders(t *testing.T) {
+	type negotiation struct {
+		Languages []string `header:"Accept-Language" list:"true"`
+		Encodings []string `header:"Accept-Encoding" list:"true"`
+		Raw       []string `header:"Accept-Language"`
+	}
+
+	tests := []struct {
+		name      string
+		languages []string
+		encodings []string
+		want      negotiation
+	}{
+		{
+			name:      "quality values",
+			languages: []string{"en-US, en;q=0.9, fr;q=0.8"},
+			want:      negotiation{Languages: []string{"en-US", "en", "fr"}, Raw: []string{"en-US", " en;q=0.9", " fr;q=0.8"}},
+		},
+		{
+			name:      "plain list",
+			encodings: []string{"gzip, deflate,br"},
+			want:      negotiation{Encodings: []string{"gzip", "deflate", "br"}},
+		},
+		{
+			name:      "several lines",
+			languages: []string{"en;q=0.9", "de, ,tr;q=0.5"},
+			want:      negotiation{Languages: []string{"en", "de", "tr"}, Raw: []string{"en;q=0.9", "de, ,tr;q=0.5"}},
+		},
+		{
+			name: "absent",
+			want: negotiation{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header["Accept-Language"] = tt.languages
+			request.Header["Accept-Encoding"] = tt.encodings
+
+			var got negotiation
+			if err := Convert(request, &got); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertAllHeaders(t *testing.T) {
+	type debug struct {
+		Header http.Header         `header:"*"`
+		Lines  map[string][]string `header:"*"`
+		Flat   map[string]string   `header:"*"`
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	request.Header.Add("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	request.Header.Add("X-Forwarded-For", "10.0.0.3")
+	request.Header.Add("x-request-id", "abc")
+
+	var got debug
+	if err := Convert(request, &got); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	wantLines := map[string][]string{
+		"X-Forwarded-For": {"10.0.0.1, 10.0.0.2", "10.0.0.3"},
+		"X-Request-Id":    {"abc"},
+	}
+
+	if !reflect.DeepEqual(got.Lines, wantLines) {
+		t.Errorf("Lines = %q, want %q", got.Lines, wantLines)
+	}
+
+	if !reflect.DeepEqual(got.Header, http.Header(wantLines)) {
+		t.Errorf("Header = %q, want %q", got.Header, wantLines)
+	}
+
+	wantFlat := map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2", "X-Request-Id": "abc"}
+	if !reflect.DeepEqual(got.Flat, wantFlat) {
+		t.Errorf("Flat = %q, want %q", got.Flat, wantFlat)
+	}
+
+	if _, ok := got.Header["Host"]; ok {
+		t.Error("Header has Host, want it left to meta:\"host\"")
+	}
+}
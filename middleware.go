@@ -0,0 +1,95 @@
+package http2struct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrorHandler writes the response for a request that failed to decode.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// decodedKey is the context key under which DecodeMiddleware stores the
+// decoded T.
+type decodedKey[T any] struct{}
+
+// DecodeMiddleware returns a handler that decodes each request into a T with
+// Decode and calls next with the value stored in the request context, from
+// which handlers retrieve it with FromContext. Requests that fail to decode
+// are passed to onError instead of next; a nil onError answers them with a
+// JSON body of the form {"error": "..."} and the status given by
+// DecodeErrorStatus.
+func DecodeMiddleware[T any](next http.Handler, onError ErrorHandler) http.Handler {
+	return DecodeMiddlewareWithDecoder[T](defaultDecoder, next, onError)
+}
+
+// DecodeMiddlewareWithDecoder works like DecodeMiddleware, decoding requests
+// with d, so that the options it was created with apply.
+func DecodeMiddlewareWithDecoder[T any](d *Decoder, next http.Handler, onError ErrorHandler) http.Handler {
+	if onError == nil {
+		onError = writeDecodeError
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v T
+
+		if err := d.Decode(r, &v); err != nil {
+			onError(w, r, err)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decodedKey[T]{}, v)))
+	})
+}
+
+// FromContext returns the T stored in ctx by DecodeMiddleware, and whether
+// there was one.
+func FromContext[T any](ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(decodedKey[T]{}).(T)
+
+	return v, ok
+}
+
+// DecodeErrorStatus returns the HTTP status answering a request that failed
+// to decode with err: 413 Request Entity Too Large for ErrBodyTooLarge and a
+// *FileSizeError, 415
+// Unsupported Media Type for a *FileTypeError, 500 Internal Server Error when
+// the destination type itself is at fault, such as for ErrUnsupportedKind, and
+// 400 Bad Request otherwise.
+func DecodeErrorStatus(err error) int {
+	var (
+		fileSizeErr    *FileSizeError
+		fileTypeErr    *FileTypeError
+		tagConflictErr *TagConflictError
+	)
+
+	switch {
+	case errors.Is(err, ErrBodyTooLarge), errors.As(err, &fileSizeErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &fileTypeErr):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrNotPointer), errors.Is(err, ErrNotStruct), errors.Is(err, ErrUnsupportedKind), errors.As(err, &tagConflictErr):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// writeDecodeError answers a request that failed to decode with err. Errors
+// in the destination type are not the client's to see, so they are answered
+// with the status text alone.
+func writeDecodeError(w http.ResponseWriter, _ *http.Request, err error) {
+	status := DecodeErrorStatus(err)
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = http.StatusText(status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
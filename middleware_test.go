@@ -0,0 +1,160 @@
+package http2struct
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMiddleware(t *testing.T) {
+	type list struct {
+		Page int `query:"page" required:"true"`
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		onError    ErrorHandler
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "success",
+			target:     "/?page=2",
+			wantStatus: http.StatusOK,
+			wantBody:   "page 2",
+		},
+		{
+			name:       "default error handler",
+			target:     "/?page=two",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"error":`,
+		},
+		{
+			name:   "custom error handler",
+			target: "/",
+			onError: func(w http.ResponseWriter, _ *http.Request, err error) {
+				http.Error(w, "custom", http.StatusUnprocessableEntity)
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "custom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, ok := FromContext[list](r.Context())
+				if !ok {
+					t.Error("FromContext() found no value")
+				}
+
+				fmt.Fprintf(w, "page %d", req.Page)
+			})
+
+			recorder := httptest.NewRecorder()
+			DecodeMiddleware[list](next, tt.onError).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+
+			if body := recorder.Body.String(); !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDecodeMiddlewareHidesDestinationErrors(t *testing.T) {
+	type unsupported struct {
+		Channel chan int `query:"channel"`
+	}
+
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("next handler called for a request failing to decode")
+	})
+
+	recorder := httptest.NewRecorder()
+	DecodeMiddleware[unsupported](next, nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?channel=1", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode error body: %v", err)
+	}
+
+	if want := http.StatusText(http.StatusInternalServerError); body["error"] != want {
+		t.Errorf("error = %q, want %q", body["error"], want)
+	}
+}
+
+func TestDecodeMiddlewareWithDecoder(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "within limit", body: `{"name":"ada"}`, wantStatus: http.StatusOK},
+		{name: "over limit", body: `{"name":"ada lovelace"}`, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	decoder := NewDecoder(WithMaxBodySize(16))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, _ := FromContext[payload](r.Context())
+				fmt.Fprint(w, req.Name)
+			})
+
+			recorder := httptest.NewRecorder()
+			DecodeMiddlewareWithDecoder[payload](decoder, next, nil).ServeHTTP(recorder, newJSONRequest("/", tt.body))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK && recorder.Body.String() != "ada" {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), "ada")
+			}
+		})
+	}
+}
+
+func TestDecodeErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "body too large", err: fmt.Errorf("failed to decode body: %w", ErrBodyTooLarge), want: http.StatusRequestEntityTooLarge},
+		{name: "file size", err: &FileSizeError{Field: "Avatar", Name: "avatar", MaxSize: 1024}, want: http.StatusRequestEntityTooLarge},
+		{name: "file type", err: &FileTypeError{Field: "Avatar", Name: "avatar", ContentType: "text/plain"}, want: http.StatusUnsupportedMediaType},
+		{name: "collected file type", err: Errors{&RequiredError{Field: "Name", Source: "query"}, &FileTypeError{Field: "Avatar"}}, want: http.StatusUnsupportedMediaType},
+		{name: "not a pointer", err: ErrNotPointer, want: http.StatusInternalServerError},
+		{name: "unsupported kind", err: fmt.Errorf("field: %w", ErrUnsupportedKind), want: http.StatusInternalServerError},
+		{name: "tag conflict", err: &TagConflictError{Field: "ID", Sources: []string{"query", "header"}}, want: http.StatusInternalServerError},
+		{name: "required", err: &RequiredError{Field: "Page", Source: "query", Name: "page"}, want: http.StatusBadRequest},
+		{name: "convert", err: &ConvertError{Field: "Page", Tag: "page", Source: "query", Err: errors.New("invalid syntax")}, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeErrorStatus(tt.err); got != tt.want {
+				t.Errorf("DecodeErrorStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package http2struct
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sources a field can be populated from, besides the decoded body.
+const (
+	sourceForm    = "form"
+	sourceFile    = "file"
+	sourceBinary  = "binary"
+	sourceHeader  = "header"
+	sourceQuery   = "query"
+	sourcePath    = "path"
+	sourceCookie  = "cookie"
+	sourceTrailer = "trailer"
+	sourceMeta    = "meta"
+	sourceAuth    = "auth"
+	sourceContext = "context"
+	sourceBody    = "body"
+
+	// sourceFallback marks fields tagged with a list of sources, such as
+	// `source:"header:X-Tenant,query:tenant"`, tried in order.
+	sourceFallback = "source"
+)
+
+// typePlan holds the reflection metadata of a destination struct type, computed
+// once per type so that Decode does not re-walk fields and tags per request.
+type typePlan struct {
+	body       bool        // Whether any field is populated from the decoded body
+	form       bool        // Whether any field is populated from a form value or file
+	binary     bool        // Whether any field is populated from the raw body as a file
+	raw        bool        // Whether any field is populated from the raw body bytes
+	trailer    bool        // Whether any field is populated from a trailer
+	decompress bool        // Whether a binary file field is tagged `decompress:"true"`
+	fields     []fieldPlan // Fields populated from a non-body source, in declaration order
+
+	conflicts []*TagConflictError // Fields carrying more than one source tag
+	version   uint64              // Version of the registered converters the plan was built with
+}
+
+// fieldPlan describes how a single struct field is populated.
+type fieldPlan struct {
+	index   int                 // Index of the field within its struct
+	field   reflect.StructField // Field metadata, including its tags
+	source  string              // Source the field is populated from
+	name    string              // Name of the value within its source
+	nested  bool                // Whether the field is a struct mapped from prefixed query or form keys
+	sources []sourceRef         // Sources tried in order, for fields with a source tag
+	embed   *typePlan           // Plan of an embedded struct whose fields are promoted, if any
+
+	unmarshal bool // Whether the field implements RequestUnmarshaler and decodes the request itself
+}
+
+// readsTrailer reports whether f is populated from a trailer, directly or
+// through the fields of an embedded struct or its list of sources.
+func (f fieldPlan) readsTrailer() bool {
+	if f.embed != nil {
+		return f.embed.trailer
+	}
+
+	return f.source == sourceTrailer || slices.ContainsFunc(f.sources, func(ref sourceRef) bool {
+		return ref.source == sourceTrailer
+	})
+}
+
+// readsQuery reports whether f is populated from the query, directly or
+// through its list of sources.
+func (f fieldPlan) readsQuery() bool {
+	return f.source == sourceQuery || slices.ContainsFunc(f.sources, func(ref sourceRef) bool {
+		return ref.source == sourceQuery
+	})
+}
+
+// sourceRef names a value within a source.
+type sourceRef struct {
+	source string
+	name   string
+}
+
+// parseSources parses a source tag such as "header:X-Tenant,query:tenant"
+// into the sources it lists, in order.
+func parseSources(tag string) []sourceRef {
+	var refs []sourceRef
+
+	for entry := range strings.SplitSeq(tag, ",") {
+		source, name, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		refs = append(refs, sourceRef{source: strings.TrimSpace(source), name: strings.TrimSpace(name)})
+	}
+
+	return refs
+}
+
+// plan returns the cached typePlan for t, building it on first use. Plans
+// depend on the registered converters, which decide whether a struct is
+// converted from a single value, so those built before a registration are
+// rebuilt, replacing the stale ones, after it.
+func (d *Decoder) plan(t reflect.Type) *typePlan {
+	version := convertersVersion.Load()
+
+	if p, ok := d.plans.Load(t); ok && p.(*typePlan).version == version {
+		return p.(*typePlan)
+	}
+
+	p := d.buildPlan(t, nil)
+	p.version = version
+
+	d.plans.Store(t, p)
+
+	return p
+}
+
+// pattern returns the cached compiled form of the regular expression expr,
+// compiling it on first use.
+func (d *Decoder) pattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := d.patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile pattern %q: %w", expr, err)
+	}
+
+	p, _ := d.patterns.LoadOrStore(expr, re)
+
+	return p.(*regexp.Regexp), nil
+}
+
+// buildPlan computes the typePlan of t, reading source tags under the names
+// configured for d. Outer lists the structs t is embedded in, whose plans
+// are being built.
+func (d *Decoder) buildPlan(t reflect.Type, outer []reflect.Type) *typePlan {
+	plan := &typePlan{}
+	outer = append(outer, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		// Exported fields of an embedded struct are promoted even when the
+		// embedded type itself is unexported.
+		if !field.IsExported() && (!field.Anonymous || field.Type.Kind() != reflect.Struct) {
+			continue
+		}
+
+		for _, name := range []string{"json", "xml", "yaml"} {
+			if tag, ok := field.Tag.Lookup(name); ok && tag != "-" {
+				plan.body = true
+			}
+		}
+
+		if sources := fieldSources(field, d.tagNames); len(sources) > 1 {
+			plan.conflicts = append(plan.conflicts, &TagConflictError{Field: field.Name, Sources: sources})
+		}
+
+		if field.IsExported() && isRequestUnmarshaler(field.Type) {
+			plan.fields = append(plan.fields, fieldPlan{index: i, field: field, unmarshal: true})
+
+			continue
+		}
+
+		source, name, ok := fieldSource(field, d.tagNames)
+		if !ok && d.isEmbeddedStruct(outer, field) {
+			embed := d.buildPlan(indirect(field.Type), outer)
+
+			plan.body = plan.body || embed.body
+			plan.form = plan.form || embed.form
+			plan.binary = plan.binary || embed.binary
+			plan.raw = plan.raw || embed.raw
+			plan.trailer = plan.trailer || embed.trailer
+			plan.decompress = plan.decompress || embed.decompress
+
+			plan.fields = append(plan.fields, fieldPlan{index: i, field: field, embed: embed})
+
+			continue
+		}
+
+		if !ok {
+			// Fields without a source tag can only be populated by a
+			// body decoder, whatever tags it understands, unless a body
+			// tag such as `json:"-"` leaves them out.
+			plan.body = plan.body || !skipsBody(field)
+
+			continue
+		}
+
+		switch source {
+		case sourceForm, sourceFile:
+			plan.form = true
+		case sourceBinary:
+			plan.binary = true
+			decompress, _ := strconv.ParseBool(field.Tag.Get("decompress"))
+			plan.decompress = plan.decompress || decompress
+		case sourceBody:
+			plan.raw = true
+		case sourceTrailer:
+			plan.trailer = true
+		}
+
+		var sources []sourceRef
+
+		if source == sourceFallback {
+			sources = parseSources(name)
+
+			for _, ref := range sources {
+				plan.form = plan.form || ref.source == sourceForm
+				plan.trailer = plan.trailer || ref.source == sourceTrailer
+			}
+		}
+
+		plan.fields = append(plan.fields, fieldPlan{
+			index:   i,
+			field:   field,
+			source:  source,
+			name:    name,
+			nested:  (source == sourceQuery || source == sourceForm) && d.isNestedStruct(field.Type),
+			sources: sources,
+		})
+	}
+
+	return plan
+}
+
+// skipsBody reports whether field is left out of the body by a json, xml or
+// yaml tag of "-".
+func skipsBody(field reflect.StructField) bool {
+	return slices.ContainsFunc([]string{"json", "xml", "yaml"}, func(name string) bool {
+		return field.Tag.Get(name) == "-"
+	})
+}
+
+// fieldSource returns the source and name a field is populated from. When a
+// field carries several source tags, the first of form, file, header, query,
+// path, cookie, trailer, meta, auth, context, body and source wins, unless the
+// Decoder was created with WithStrictTags. Fields without a source tag are left
+// to the body decoder. Sources listed in tagNames are read from the tag with
+// the given name instead of their own.
+func fieldSource(field reflect.StructField, tagNames map[string]string) (string, string, bool) {
+	sources := fieldSources(field, tagNames)
+	if len(sources) == 0 {
+		return "", "", false
+	}
+
+	source := sources[0]
+	if source == sourceBinary {
+		return source, "binary", true
+	}
+
+	key := source
+	if name, ok := tagNames[source]; ok {
+		key = name
+	}
+
+	return source, field.Tag.Get(key), true
+}
+
+// fieldSources returns every source field carries a tag for, in the order of
+// precedence used by fieldSource.
+func fieldSources(field reflect.StructField, tagNames map[string]string) []string {
+	var sources []string
+
+	for _, source := range []string{sourceForm, sourceFile, sourceHeader, sourceQuery, sourcePath, sourceCookie, sourceTrailer, sourceMeta, sourceAuth, sourceContext, sourceBody, sourceFallback} {
+		key := source
+		if name, ok := tagNames[source]; ok {
+			key = name
+		}
+
+		tag, ok := field.Tag.Lookup(key)
+		if !ok || tag == "-" {
+			continue
+		}
+
+		// The body tag takes no name, so `body:""` is enough to select it.
+		if tag == "" && source != sourceBody {
+			continue
+		}
+
+		if source == sourceFile && tag == "binary" {
+			source = sourceBinary
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+// isEmbeddedStruct reports whether field embeds a struct, or pointer to struct,
+// whose fields are promoted into the innermost of outer, the chain of structs
+// being planned. Pointers back to a struct of the chain, as in a type embedding
+// a pointer to itself or two types embedding pointers to each other, are not
+// followed.
+func (d *Decoder) isEmbeddedStruct(outer []reflect.Type, field reflect.StructField) bool {
+	if !field.Anonymous || !d.isNestedStruct(field.Type) || slices.Contains(outer, indirect(field.Type)) {
+		return false
+	}
+
+	return field.IsExported() || field.Type.Kind() == reflect.Struct
+}
+
+// indirect returns the element type of t if t is a pointer, or t otherwise.
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+
+	return t
+}
+
+// isNestedStruct reports whether t is a struct, or pointer to struct, whose
+// fields are mapped individually rather than converted from a single value.
+func (d *Decoder) isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || baseType(t) == timeType || baseType(t) == urlType {
+		return false
+	}
+
+	if _, ok := lookupConverter(t); ok {
+		return false
+	}
+
+	return !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
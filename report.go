@@ -0,0 +1,131 @@
+package http2struct
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Report lists the fields of a destination that received a value from the
+// request, as returned by ConvertWithReport.
+type Report struct {
+	Fields []ReportField // Populated fields, in the order they were decoded
+	Body   []byte        // Body bytes read by the body decoder, such as a JSON payload, or nil
+}
+
+// ReportField describes a field that received a value from the request.
+type ReportField struct {
+	Path   string // Name of the field, preceded by those of enclosing nested structs and a dot
+	Source string // Source of the value, such as query or header, or body for JSON body fields
+	Name   string // Name of the value within its source
+}
+
+// Has reports whether the field at path, such as "Name" or "Address.City",
+// received a value from the request.
+func (r Report) Has(path string) bool {
+	return slices.ContainsFunc(r.Fields, func(f ReportField) bool {
+		return f.Path == path
+	})
+}
+
+// Paths returns the paths of the populated fields.
+func (r Report) Paths() []string {
+	paths := make([]string, len(r.Fields))
+
+	for i, f := range r.Fields {
+		paths[i] = f.Path
+	}
+
+	return paths
+}
+
+func (r *Report) add(path, source, name string) {
+	r.Fields = append(r.Fields, ReportField{Path: path, Source: source, Name: name})
+}
+
+// addJSON adds the fields of the struct type t whose keys are present in the
+// top-level object of the JSON body, or of its root member when root is not
+// empty. Keys match the json tag, or the field name, like encoding/json does.
+// Fields tagged with a source, as named by tagNames, are filled from it rather
+// than from the body and are left out.
+func (r *Report) addJSON(t reflect.Type, body []byte, root string, tagNames map[string]string) {
+	var object map[string]json.RawMessage
+
+	if err := json.Unmarshal(body, &object); err != nil {
+		return
+	}
+
+	if root != "" {
+		member := object[root]
+		object = nil
+
+		if err := json.Unmarshal(member, &object); err != nil {
+			return
+		}
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || (field.Anonymous && field.Tag.Get("json") == "") || len(fieldSources(field, tagNames)) > 0 {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if _, ok := object[name]; ok {
+			r.add(field.Name, sourceBody, name)
+
+			continue
+		}
+
+		for key := range object {
+			if strings.EqualFold(key, name) {
+				r.add(field.Name, sourceBody, key)
+
+				break
+			}
+		}
+	}
+}
+
+// decodesBody reports whether convertBody hands the body of request to a body
+// decoder, so that the bytes it reads can be reported.
+func (d *Decoder) decodesBody(request *http.Request) bool {
+	if request.ContentLength == 0 {
+		return false
+	}
+
+	if d.bodyMethods != nil && !slices.Contains(d.bodyMethods, request.Method) {
+		return false
+	}
+
+	base, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+
+	_, ok := d.lookupBodyDecoder(strings.TrimSpace(base))
+
+	return ok
+}
+
+// isJSONBody reports whether d decodes the body of request as JSON, so that
+// its keys can be reported.
+func (d *Decoder) isJSONBody(request *http.Request) bool {
+	if d.bodyMethods != nil && !slices.Contains(d.bodyMethods, request.Method) {
+		return false
+	}
+
+	return isJSONType(mediaType(request.Header.Get("Content-Type")))
+}
+
+// isJSONType reports whether the media type t is JSON, such as
+// application/json or application/vnd.api+json.
+func isJSONType(t string) bool {
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
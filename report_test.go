@@ -0,0 +1,172 @@
+package http2struct
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestConvertWithReport(t *testing.T) {
+	type address struct {
+		City string `query:"city"`
+	}
+
+	type patch struct {
+		Name    string  `json:"name"`
+		Email   string  `json:"email"`
+		Notify  bool    `query:"notify" default:"true"`
+		Token   string  `header:"X-Token"`
+		Address address `query:"address"`
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    []ReportField
+	}{
+		{
+			name:    "body keys only",
+			request: func() *http.Request { return newJSONRequest("/", `{"email":""}`) },
+			want:    []ReportField{{Path: "Email", Source: "body", Name: "email"}},
+		},
+		{
+			name: "every source",
+			request: func() *http.Request {
+				request := newJSONRequest("/?notify=false&address.city=Izmir", `{"name":"ada"}`)
+				request.Header.Set("X-Token", "secret")
+
+				return request
+			},
+			want: []ReportField{
+				{Path: "Name", Source: "body", Name: "name"},
+				{Path: "Notify", Source: "query", Name: "notify"},
+				{Path: "Token", Source: "header", Name: "X-Token"},
+				{Path: "Address.City", Source: "query", Name: "address.city"},
+			},
+		},
+		{
+			name:    "defaults not reported",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodPatch, "/", nil) },
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got patch
+
+			report, err := ConvertWithReport(tt.request(), &got)
+			if err != nil {
+				t.Fatalf("ConvertWithReport() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(report.Fields, tt.want) {
+				t.Errorf("Fields = %+v, want %+v", report.Fields, tt.want)
+			}
+
+			for _, field := range tt.want {
+				if !report.Has(field.Path) {
+					t.Errorf("Has(%q) = false, want true", field.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertWithReportBody(t *testing.T) {
+	type event struct {
+		Type string `json:"type"`
+		Page int    `query:"page"`
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    string
+	}{
+		{
+			name:    "json body",
+			request: func() *http.Request { return newJSONRequest("/", `{"type": "push",  "extra": [1, 2]}`) },
+			want:    `{"type": "push",  "extra": [1, 2]}`,
+		},
+		{
+			name: "gzip body",
+			request: func() *http.Request {
+				var compressed bytes.Buffer
+
+				writer := gzip.NewWriter(&compressed)
+				_, _ = writer.Write([]byte(`{"type":"zip"}`))
+				_ = writer.Close()
+
+				request := newBodyRequest("/", "application/json", compressed.String())
+				request.Header.Set("Content-Encoding", "gzip")
+
+				return request
+			},
+			want: `{"type":"zip"}`,
+		},
+		{
+			name:    "no body decoder",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "/?page=2", nil) },
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got event
+
+			report, err := ConvertWithReport(tt.request(), &got)
+			if err != nil {
+				t.Fatalf("ConvertWithReport() error = %v", err)
+			}
+
+			if string(report.Body) != tt.want {
+				t.Errorf("Body = %q, want %q", report.Body, tt.want)
+			}
+
+			if tt.want == "" {
+				if report.Body != nil {
+					t.Errorf("Body = %q, want nil", report.Body)
+				}
+
+				return
+			}
+
+			var decoded event
+			if err := json.Unmarshal(report.Body, &decoded); err != nil || decoded.Type != got.Type {
+				t.Errorf("Body decodes to %+v, %v, want type %q", decoded, err, got.Type)
+			}
+		})
+	}
+}
+
+func TestConvertWithReportUntaggedJSONNames(t *testing.T) {
+	type signup struct {
+		Name  string `json:",omitempty"`
+		Email string `validate:"required"`
+		Age   int
+		Page  int `query:"page"`
+	}
+
+	var got signup
+
+	report, err := ConvertWithReport(newJSONRequest("/", `{"Name":"a","Email":"e","age":3,"Page":4}`), &got)
+	if err != nil {
+		t.Fatalf("ConvertWithReport() error = %v", err)
+	}
+
+	want := []ReportField{
+		{Path: "Name", Source: "body", Name: "Name"},
+		{Path: "Email", Source: "body", Name: "Email"},
+		{Path: "Age", Source: "body", Name: "age"},
+	}
+
+	if !reflect.DeepEqual(report.Fields, want) {
+		t.Errorf("Fields = %+v, want %+v", report.Fields, want)
+	}
+}